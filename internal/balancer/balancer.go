@@ -0,0 +1,154 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upstream is one backend of a route.
+type Upstream struct {
+	URL    *url.URL
+	Weight int
+
+	active    atomic.Int64
+	unhealthy atomic.Bool
+	// ejectedUntil is the Unix time in nanoseconds until which the upstream is ejected
+	ejectedUntil atomic.Int64
+}
+
+func NewUpstream(u *url.URL, weight int) *Upstream {
+	if weight <= 0 {
+		weight = 1
+	}
+
+	return &Upstream{URL: u, Weight: weight}
+}
+
+// Acquire counts a request the upstream is handling, Release has to follow once it is done.
+func (u *Upstream) Acquire() {
+	u.active.Add(1)
+}
+
+func (u *Upstream) Release() {
+	u.active.Add(-1)
+}
+
+// Active returns how many requests the upstream is currently handling.
+func (u *Upstream) Active() int64 {
+	return u.active.Load()
+}
+
+// Healthy reports whether the upstream is in rotation. Upstreams are healthy
+// until a health check says otherwise.
+func (u *Upstream) Healthy() bool {
+	return !u.unhealthy.Load()
+}
+
+func (u *Upstream) SetHealthy(healthy bool) {
+	u.unhealthy.Store(!healthy)
+}
+
+// Eject takes the upstream out of rotation until the given time, regardless of its health.
+func (u *Upstream) Eject(until time.Time) {
+	u.ejectedUntil.Store(until.UnixNano())
+}
+
+// EjectedUntil returns until when the upstream is ejected, a time in the past if it isn't.
+func (u *Upstream) EjectedUntil() time.Time {
+	return time.Unix(0, u.ejectedUntil.Load())
+}
+
+// Available reports whether requests may be sent to the upstream, which is
+// healthy and not ejected.
+func (u *Upstream) Available() bool {
+	return u.Healthy() && time.Now().UnixNano() >= u.ejectedUntil.Load()
+}
+
+// Balancer chooses the upstream a request is sent to. Implementations are
+// used by concurrent requests and have to synchronise their state.
+type Balancer interface {
+	// Pick returns one of upstreams for r, upstreams is never empty and only
+	// holds available upstreams.
+	Pick(upstreams []*Upstream, r *http.Request) *Upstream
+}
+
+// Config selects and configures the policy of a route.
+type Config struct {
+	Policy string
+	// HashKey is what ConsistentHash hashes: "ip", "header:<name>" or "cookie:<name>".
+	HashKey string
+}
+
+// Factory creates a balancer of one policy for a route.
+type Factory func(config Config) (Balancer, error)
+
+const (
+	RoundRobin         = "round_robin"
+	WeightedRoundRobin = "weighted_round_robin"
+	LeastConnections   = "least_connections"
+	RandomTwoChoices   = "random_two_choices"
+	ConsistentHash     = "consistent_hash"
+)
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]Factory{
+		RoundRobin:         func(Config) (Balancer, error) { return &roundRobin{}, nil },
+		WeightedRoundRobin: func(Config) (Balancer, error) { return newWeightedRoundRobin(), nil },
+		LeastConnections:   func(Config) (Balancer, error) { return leastConnections{}, nil },
+		RandomTwoChoices:   func(Config) (Balancer, error) { return randomTwoChoices{}, nil },
+		ConsistentHash:     newConsistentHash,
+	}
+)
+
+// Register makes a policy available to the configuration under name, replacing
+// a policy registered under the same name before.
+func Register(name string, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	registry[name] = factory
+}
+
+// Unregister removes the policy registered under name.
+func Unregister(name string) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	delete(registry, name)
+}
+
+// Policies returns the names of all registered policies.
+func Policies() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// New creates the balancer configured by config, round robin if no policy is set.
+func New(config Config) (Balancer, error) {
+	if config.Policy == "" {
+		config.Policy = RoundRobin
+	}
+
+	registryMutex.RLock()
+	factory, ok := registry[config.Policy]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown balancing policy %q, available are %v", config.Policy, Policies())
+	}
+
+	return factory(config)
+}
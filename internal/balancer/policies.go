@@ -0,0 +1,155 @@
+package balancer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// roundRobin hands out upstreams in turn, ignoring their weights.
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (b *roundRobin) Pick(upstreams []*Upstream, _ *http.Request) *Upstream {
+	return upstreams[(b.next.Add(1)-1)%uint64(len(upstreams))]
+}
+
+// weightedRoundRobin is the smooth weighted round robin of nginx, which spreads
+// the turns of heavy upstreams instead of sending them in bursts.
+type weightedRoundRobin struct {
+	mutex   sync.Mutex
+	current map[*Upstream]int
+}
+
+func newWeightedRoundRobin() *weightedRoundRobin {
+	return &weightedRoundRobin{current: make(map[*Upstream]int)}
+}
+
+func (b *weightedRoundRobin) Pick(upstreams []*Upstream, _ *http.Request) *Upstream {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var best *Upstream
+	total := 0
+	for _, upstream := range upstreams {
+		b.current[upstream] += upstream.Weight
+		total += upstream.Weight
+		if best == nil || b.current[upstream] > b.current[best] {
+			best = upstream
+		}
+	}
+	b.current[best] -= total
+
+	return best
+}
+
+// load compares upstreams by their requests in flight relative to their weight.
+func load(upstream *Upstream) float64 {
+	return float64(upstream.Active()) / float64(upstream.Weight)
+}
+
+// leastConnections picks the upstream with the fewest requests in flight per weight.
+type leastConnections struct{}
+
+func (leastConnections) Pick(upstreams []*Upstream, _ *http.Request) *Upstream {
+	best := upstreams[0]
+	for _, upstream := range upstreams[1:] {
+		if load(upstream) < load(best) {
+			best = upstream
+		}
+	}
+
+	return best
+}
+
+// randomTwoChoices picks the less loaded of two random upstreams, which comes
+// close to least connections without every request going to the same idle upstream.
+type randomTwoChoices struct{}
+
+func (randomTwoChoices) Pick(upstreams []*Upstream, _ *http.Request) *Upstream {
+	if len(upstreams) == 1 {
+		return upstreams[0]
+	}
+
+	first := rand.IntN(len(upstreams))
+	second := rand.IntN(len(upstreams) - 1)
+	if second >= first {
+		second++
+	}
+
+	if load(upstreams[second]) < load(upstreams[first]) {
+		return upstreams[second]
+	}
+	return upstreams[first]
+}
+
+// consistentHash sends requests with the same key to the same upstream using
+// weighted rendezvous hashing. When an upstream drops out only its keys move.
+type consistentHash struct {
+	key func(r *http.Request) string
+}
+
+func newConsistentHash(config Config) (Balancer, error) {
+	source, name, _ := strings.Cut(config.HashKey, ":")
+
+	b := &consistentHash{}
+	switch {
+	case source == "" || source == "ip":
+		b.key = clientIP
+	case source == "header" && name != "":
+		b.key = func(r *http.Request) string {
+			return r.Header.Get(name)
+		}
+	case source == "cookie" && name != "":
+		b.key = func(r *http.Request) string {
+			if cookie, err := r.Cookie(name); err == nil {
+				return cookie.Value
+			}
+			return ""
+		}
+	default:
+		return nil, fmt.Errorf("hash key has to be \"ip\", \"header:<name>\" or \"cookie:<name>\", not %q", config.HashKey)
+	}
+
+	return b, nil
+}
+
+func clientIP(r *http.Request) string {
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+func (b *consistentHash) Pick(upstreams []*Upstream, r *http.Request) *Upstream {
+	key := b.key(r)
+	if key == "" {
+		// Requests without the key are spread by client instead
+		key = clientIP(r)
+	}
+
+	var best *Upstream
+	bestScore := math.Inf(-1)
+	for _, upstream := range upstreams {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(upstream.URL.String()))
+		_, _ = hash.Write([]byte{0})
+		_, _ = hash.Write([]byte(key))
+
+		// Map the hash into (0, 1) and weigh it, see "Weighted rendezvous hashing"
+		unit := (float64(hash.Sum64()>>11) + 0.5) / (1 << 53)
+		score := -float64(upstream.Weight) / math.Log(unit)
+		if score > bestScore {
+			best, bestScore = upstream, score
+		}
+	}
+
+	return best
+}
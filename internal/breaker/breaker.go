@@ -0,0 +1,105 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrOpen is returned when a route has no room for another request.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Config caps the load a route puts on its upstreams, zero leaves a cap unset.
+type Config struct {
+	// MaxRequests is how many requests may be forwarded at the same time.
+	MaxRequests int
+	// MaxPending is how many requests may wait for one of MaxRequests to finish,
+	// all others fail right away.
+	MaxPending int
+	// MaxRetries is how many requests may be retried at the same time.
+	MaxRetries int
+}
+
+// Breaker fails requests fast once a route is saturated instead of letting them
+// pile up on upstreams that are already struggling. A nil Breaker caps nothing.
+type Breaker struct {
+	config  Config
+	slots   chan struct{}
+	pending atomic.Int64
+	retries atomic.Int64
+}
+
+func New(config Config) *Breaker {
+	b := &Breaker{config: config}
+	if config.MaxRequests > 0 {
+		b.slots = make(chan struct{}, config.MaxRequests)
+	}
+	return b
+}
+
+// Acquire takes one of the request slots, waiting for one if all are taken and
+// MaxPending allows it. It returns ErrOpen if the request can't wait, or the
+// error of ctx if it ends first. release has to be called once the request is done.
+func (b *Breaker) Acquire(ctx context.Context) (release func(), err error) {
+	if b == nil || b.slots == nil {
+		return func() {}, nil
+	}
+	release = func() {
+		<-b.slots
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if b.pending.Add(1) > int64(b.config.MaxPending) {
+		b.pending.Add(-1)
+		return nil, ErrOpen
+	}
+	defer b.pending.Add(-1)
+
+	select {
+	case b.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// AcquireRetry reports whether another retry may be sent, ReleaseRetry has to
+// follow once it is done if it may.
+func (b *Breaker) AcquireRetry() bool {
+	if b == nil || b.config.MaxRetries <= 0 {
+		return true
+	}
+
+	if b.retries.Add(1) > int64(b.config.MaxRetries) {
+		b.retries.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (b *Breaker) ReleaseRetry() {
+	if b != nil && b.config.MaxRetries > 0 {
+		b.retries.Add(-1)
+	}
+}
+
+// Active returns how many requests hold a slot.
+func (b *Breaker) Active() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.slots)
+}
+
+// Pending returns how many requests wait for a slot.
+func (b *Breaker) Pending() int {
+	if b == nil {
+		return 0
+	}
+	return int(b.pending.Load())
+}
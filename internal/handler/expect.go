@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// expectContinueTimeout is how long the upstream gets to answer a forwarded
+// Expect: 100-continue before the body is sent anyway.
+const expectContinueTimeout = time.Second
+
+// continueReader sends the 100 Continue interim response the first time the
+// body is read. Handlers that reject a request before touching its body
+// therefore answer with their final status and the client never uploads it.
+type continueReader struct {
+	body io.ReadCloser
+	w    http.ResponseWriter
+	sent bool
+}
+
+func newContinueReader(body io.ReadCloser, w http.ResponseWriter) *continueReader {
+	return &continueReader{body: body, w: w}
+}
+
+func (cr *continueReader) Read(p []byte) (int, error) {
+	if !cr.sent {
+		cr.sent = true
+		cr.w.WriteHeader(http.StatusContinue)
+	}
+
+	return cr.body.Read(p)
+}
+
+func (cr *continueReader) Close() error {
+	return cr.body.Close()
+}
@@ -3,26 +3,26 @@ package handler
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"fmt"
 	cache_structs "httpServer/internal/cache/structs"
 	"io"
 	"net"
 	"net/http"
-	"net/url"
-	"os"
-	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	hpack "github.com/tatsuhiro-t/go-http2-hpack"
 
+	"httpServer/internal/balancer"
 	"httpServer/internal/http2/frame"
 	"httpServer/internal/http2/structs"
 	"httpServer/internal/logging"
+	"httpServer/internal/request"
 	http11 "httpServer/internal/request/http1.1"
 	"httpServer/internal/request/http2"
 	http11Response "httpServer/internal/response/http1.1"
@@ -33,7 +33,17 @@ import (
 var Proxy proxystructs.ProxyHandler
 var Channels cache_structs.Channels
 
+const (
+	lingerTimeout  = 2 * time.Second
+	lingerMaxBytes = 256 * 1024
+)
+
 func InitHandler(proxy proxystructs.ProxyHandler, channels cache_structs.Channels) {
+	if Proxy != nil && Proxy != proxy {
+		// The routes of the previous configuration won't be used again
+		retireRoutes(Proxy.GetRoutes())
+	}
+	startResolvers(proxy.GetRoutes())
 	Proxy = proxy
 	Channels = channels
 }
@@ -56,142 +66,337 @@ func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func resolveRoute(route []proxystructs.ProxyRoute, path string) *proxystructs.ProxyRoute {
-	for _, route := range route {
-		if route.Path == path {
-			return &route
-		}
+// checkLimits returns the status code a request exceeding limits is rejected with, 0 if it fits.
+func checkLimits(limits proxystructs.RequestLimits, r *http.Request) int {
+	if limits.MaxURILength > 0 && len(r.RequestURI) > limits.MaxURILength {
+		return http.StatusRequestURITooLong
 	}
 
-	return nil
-}
-
-// TODO: Finish
-func loadSystemCAs() *x509.CertPool {
-	Proxy.Log(logging.LogLevelDebug, "Loading system certificates")
-	var certPool *x509.CertPool
-	var err error
-
-	if runtime.GOOS == "windows" {
-		// On Windows, use the system certificate pool
-		certPool, err = x509.SystemCertPool()
-		if err != nil {
-			Proxy.Log(logging.LogLevelError, "Failed to load system CA certificates on Windows: %v", err)
-			return nil
-		}
-		Proxy.Log(logging.LogLevelDebug, "System certificate pool loaded on Windows")
-	} else {
-		// On Unix-like systems, manually load the certificate bundle
-		systemCerts, err := os.ReadFile("/etc/ssl/certs/ca-certificates.crt")
-		if err != nil {
-			if os.IsNotExist(err) {
-				Proxy.Log(logging.LogLevelError, "System CA cert file not found, please ensure your system has CA certificates installed.")
-			} else {
-				Proxy.Log(logging.LogLevelError, "Failed to load system CA certificates: %v", err)
+	size, parsed := request.HeaderSizeOf(r)
+	if !parsed {
+		for name, values := range r.Header {
+			for _, value := range values {
+				size.AddField(name, value)
 			}
-			return nil
 		}
+	}
+	// The HTTP/1.1 parser already held the header to the global limits, only tighter ones of the route are left
+	parserOptions := Proxy.GetParserOptions()
+	enforced := parsed && r.ProtoMajor == 1
+	if tighterLimit(limits.MaxHeaderCount, parserOptions.MaxHeaderCount, enforced) && size.Fields > limits.MaxHeaderCount {
+		return http.StatusRequestHeaderFieldsTooLarge
+	}
+	if tighterLimit(limits.MaxHeaderBytes, parserOptions.MaxHeaderBytes, enforced) && size.Bytes > limits.MaxHeaderBytes {
+		return http.StatusRequestHeaderFieldsTooLarge
+	}
 
-		certPool = x509.NewCertPool()
-		if ok := certPool.AppendCertsFromPEM(systemCerts); !ok {
-			Proxy.Log(logging.LogLevelError, "Failed to append system CA certificates")
-			return nil
-		}
+	if limits.MaxBodyBytes > 0 && r.ContentLength > limits.MaxBodyBytes {
+		return http.StatusRequestEntityTooLarge
 	}
 
-	if certPool == nil || len(certPool.Subjects()) == 0 {
-		Proxy.Log(logging.LogLevelError, "Loaded certificate pool is empty")
-		return nil // Return nil in case of failure
+	return 0
+}
+
+// tighterLimit reports whether limit has to be checked, that is it is set and
+// either nothing was enforced yet or it is tighter than the enforced one.
+func tighterLimit(limit, enforcedLimit int, enforced bool) bool {
+	if limit <= 0 {
+		return false
 	}
+	return !enforced || enforcedLimit <= 0 || limit < enforcedLimit
+}
 
-	Proxy.Log(logging.LogLevelDebug, "Successfully loaded system CA certificates")
-	return certPool
+// checkRoute returns the status a request is rejected with by its route before
+// anything is forwarded, 0 if it is served.
+func checkRoute(r *http.Request) int {
+	forwardRoute, _ := resolveRoute(Proxy.GetRoutes(), r.Host, requestPath(r.URL))
+	if forwardRoute == nil {
+		return http.StatusNotFound
+	}
+	return checkLimits(forwardRoute.Limits, r)
 }
 
 // ReverseProxyHandler TODO: Add caching
 func ReverseProxyHandler(w http.ResponseWriter, r *http.Request) {
-	forwardRoute := resolveRoute(Proxy.GetRoutes(), r.URL.Path)
+	forwardRoute, targetPath := resolveRoute(Proxy.GetRoutes(), r.Host, requestPath(r.URL))
 	if forwardRoute == nil {
-		Proxy.Log(logging.LogLevelWarn, "Forwarding to nil target: %s %s", r.Method, r.URL.Path)
-		w.WriteHeader(http.StatusBadGateway)
+		NotFoundHandler(w, r)
+		return
+	}
+	if !withinTarget(targetPath, forwardRoute.TargetPath) {
+		Proxy.Log(logging.LogLevelWarn, "Rejected path %s leaving target path %s of route %s", r.URL.EscapedPath(), forwardRoute.TargetPath, forwardRoute.Path)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	forward(w, r, forwardRoute, targetPath)
+}
+
+// forward sends r to targetPath on the upstream of forwardRoute and copies the answer to w.
+func forward(w http.ResponseWriter, r *http.Request, forwardRoute *proxystructs.ProxyRoute, targetPath string) {
+	if statusCode := checkLimits(forwardRoute.Limits, r); statusCode != 0 {
+		Proxy.Log(logging.LogLevelWarn, "Request exceeds the limits of route %s: %s %s -> %d", forwardRoute.Path, r.Method, r.URL.Path, statusCode)
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(statusCode)
 		return
 	}
 
-	targetURL := forwardRoute.Host.ResolveReference(&url.URL{Path: forwardRoute.TargetPath})
+	body := r.Body
+	var clientBody *trackedBody
+	if body != nil && body != http.NoBody {
+		clientBody = &trackedBody{ReadCloser: body}
+		body = clientBody
+	}
+	if body != nil && forwardRoute.Limits.MaxBodyBytes > 0 {
+		body = http.MaxBytesReader(w, body, forwardRoute.Limits.MaxBodyBytes)
+	}
 
-	req, err := http.NewRequest(r.Method, targetURL.String(), r.Body)
+	release, err := forwardRoute.Breaker.Acquire(r.Context())
 	if err != nil {
-		Proxy.Log(logging.LogLevelError, "New request creation failed in ReverseProxyHandler: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		Proxy.Log(logging.LogLevelWarn, "Circuit breaker of route %s is open, answering %s %s with 503: %v", forwardRoute.Path, r.Method, r.URL.Path, err)
+		w.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
-	req.Header = r.Header.Clone()
+	defer release()
+
+	header := r.Header.Clone()
+	removeHopByHopHeaders(header, forwardRoute.PassThroughHeaders)
+	if acceptsTrailers(r.Header) {
+		header.Set("TE", "trailers")
+	}
+
+	// Upgrades are only tunnelled on routes allowing them and over connections that can be taken over
+	upgrade := ""
+	if _, hijackable := w.(http.Hijacker); hijackable && forwardRoute.AllowUpgrade && isUpgradeRequest(r.Header) {
+		upgrade = r.Header.Get("Upgrade")
+		header.Set("Connection", "Upgrade")
+		header.Set("Upgrade", upgrade)
+	}
+
+	// The expectation is answered here, the upstream only sees it if the route forwards it
+	forwardExpect := forwardRoute.ForwardExpectContinue && strings.EqualFold(header.Get("Expect"), "100-continue")
+	header.Del("Expect")
 
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		Proxy.Log(logging.LogLevelError, "Failed to parse remote address: %s %v", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+	if prior := header.Get("X-Forwarded-For"); prior != "" {
 		ip = prior + ", " + ip
 	}
-	req.Header.Set("X-Forwarded-For", ip)
+	header.Set("X-Forwarded-For", ip)
 
 	for key, values := range Proxy.GetAddedHeaders() {
 		for _, value := range values {
-			req.Header.Add(key, value)
+			header.Add(key, value)
 		}
 	}
 
-	// Setting up HTTP client with system CA certificates
-	/* TODO: Uncomment when loadSystemCAs works
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs: loadSystemCAs(),
-			},
-		},
+	transport := forwardRoute.Transport
+	if transport == nil {
+		// Routes the proxy didn't build have nothing to pool connections in
+		transport = NewTransport(forwardRoute)
+		defer transport.CloseIdleConnections()
+	}
+	if forwardExpect && r.ContentLength != 0 {
+		// The body is only requested from the client once the upstream sent its 100 Continue
+		header.Set("Expect", "100-continue")
 	}
-	*/
 
 	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
+		Transport: transport,
+	}
+
+	// Bodies are kept to be sent again unless they are too large, or have to be
+	// passed on as they arrive for a tunnel or an expectation of the upstream
+	retries := newRetrier(forwardRoute, r, forwardRoute.Retries != nil && upgrade == "" && !forwardExpect)
+	defer retries.done()
+
+	var replay []byte
+	if retries.replayable && body != nil && body != http.NoBody {
+		buffered, rest, err := bufferBody(body, forwardRoute.Retries.MaxBodyBytes)
+		switch {
+		case err != nil:
+			writeBodyError(w, forwardRoute, err)
+			return
+		case rest != nil:
+			body = rest
+			retries.replayable = false
+		default:
+			replay = buffered
+		}
 	}
 
-	resp, err := client.Do(req)
+	// The total timeout covers retries as well, tunnels have an idle timeout of their own instead
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	if timeout, hinted := requestTimeout(r, forwardRoute.Timeouts.Total); timeout > 0 && upgrade == "" {
+		var cause error
+		if hinted {
+			cause = errClientDeadline
+		}
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, timeout, cause)
+		defer cancelTimeout()
+	}
+
+	var upstream *balancer.Upstream
+	var resp *http.Response
+	var tried []*balancer.Upstream
+	for {
+		upstream = pickUpstream(forwardRoute, r, tried)
+		if upstream == nil {
+			Proxy.Log(logging.LogLevelError, "No available upstream left on route %s, all are unhealthy or ejected, answering %s %s with 503", forwardRoute.Path, r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		tried = append(tried, upstream)
+
+		attemptBody := body
+		if replay != nil {
+			attemptBody = io.NopCloser(bytes.NewReader(replay))
+		}
+
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, r.Method, upstreamURL(upstream.URL, targetPath, r.URL.RawQuery).String(), attemptBody)
+		if err != nil {
+			Proxy.Log(logging.LogLevelError, "New request creation failed in ReverseProxyHandler: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		req.Header = header.Clone()
+		req.ContentLength = r.ContentLength
+		req.Trailer = r.Trailer
+		req.Host = hostHeader(forwardRoute, r)
+
+		upstream.Acquire()
+		resp, err = client.Do(req)
+		// Failures reading from the client or its own deadline aren't the upstream's fault
+		clientFailed := err != nil && (clientBodyFailed(err, clientBody) || clientGaveUp(r, ctx))
+		if err == nil {
+			reportOutcome(forwardRoute, upstream, resp.StatusCode >= http.StatusInternalServerError)
+		} else if !clientFailed {
+			reportOutcome(forwardRoute, upstream, true)
+		}
+
+		if clientFailed || !retries.retry(ctx, resp, err) {
+			break
+		}
+		upstream.Release()
+		if err != nil {
+			Proxy.Log(logging.LogLevelInfo, "Retrying %s %s on route %s after upstream %s failed: %v", r.Method, r.URL.Path, forwardRoute.Path, upstream.URL, err)
+		} else {
+			Proxy.Log(logging.LogLevelInfo, "Retrying %s %s on route %s after upstream %s answered %d", r.Method, r.URL.Path, forwardRoute.Path, upstream.URL, resp.StatusCode)
+		}
+		discard(resp)
+	}
+	defer upstream.Release()
+
 	if err != nil {
+		if clientBodyFailed(err, clientBody) {
+			writeBodyError(w, forwardRoute, err)
+			return
+		}
+		if errors.Is(context.Cause(ctx), errClientDeadline) {
+			Proxy.Log(logging.LogLevelInfo, "Timeout requested by the client ran out on %s %s of route %s", r.Method, r.URL.Path, forwardRoute.Path)
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		if upstreamTimedOut(ctx, err) {
+			Proxy.Log(logging.LogLevelWarn, "Upstream %s of route %s timed out on %s %s: %v", upstream.URL, forwardRoute.Path, r.Method, r.URL.Path, err)
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
 		Proxy.Log(logging.LogLevelError, "Request forwarding failed in ReverseProxyHandler: %v", err)
 		w.WriteHeader(http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		switchProtocols(w, resp, forwardRoute, upgrade)
+		return
+	}
+
+	removeHopByHopHeaders(resp.Header, forwardRoute.PassThroughHeaders)
 	for name, values := range resp.Header {
 		for _, value := range values {
 			w.Header().Add(name, value)
 		}
 	}
+	// Trailers are announced up front and filled in once the body is copied
+	for name := range resp.Trailer {
+		w.Header().Add("Trailer", name)
+	}
 	w.WriteHeader(resp.StatusCode)
 	Proxy.Log(logging.LogLevelDebug, "Received status code: %d", resp.StatusCode)
 
-	var buffer bytes.Buffer
-	_, err = io.Copy(&buffer, resp.Body)
+	err = copyResponse(w, newIdleTimeoutBody(resp.Body, forwardRoute.Timeouts.BodyIdle, cancel), flushIntervalFor(resp, forwardRoute.FlushInterval))
 	if err != nil {
-		Proxy.Log(logging.LogLevelError, "Response body copy failed in ReverseProxyHandler: %v", err)
+		if ctx.Err() != nil {
+			Proxy.Log(logging.LogLevelWarn, "Upstream %s of route %s timed out sending the body of %s %s: %v", upstream.URL, forwardRoute.Path, r.Method, r.URL.Path, context.Cause(ctx))
+		} else {
+			Proxy.Log(logging.LogLevelError, "Response body copy failed in ReverseProxyHandler: %v", err)
+		}
+		if a, ok := w.(aborter); ok {
+			a.Abort()
+		}
 		return
 	}
 
-	_, err = w.Write(buffer.Bytes())
+	for name, values := range resp.Trailer {
+		w.Header()[name] = values
+	}
+
+	Proxy.Log(logging.LogLevelDebug, "Reverse proxy handler finished")
+}
+
+// writeBodyError answers a request whose body couldn't be read from the client.
+func writeBodyError(w http.ResponseWriter, route *proxystructs.ProxyRoute, err error) {
+	w.Header().Set("Connection", "close")
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		Proxy.Log(logging.LogLevelWarn, "Request body exceeds %d bytes on route %s", maxBytesErr.Limit, route.Path)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	Proxy.Log(logging.LogLevelWarn, "Failed to read the request body on route %s: %v", route.Path, err)
+	w.WriteHeader(http.StatusBadRequest)
+}
+
+// switchProtocols answers the client with the upstream's 101 Switching Protocols
+// and tunnels the upgraded connection.
+func switchProtocols(w http.ResponseWriter, resp *http.Response, route *proxystructs.ProxyRoute, requested string) {
+	protocol := resp.Header.Get("Upgrade")
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if requested == "" || !strings.EqualFold(protocol, requested) || !ok {
+		Proxy.Log(logging.LogLevelWarn, "Upstream of route %s switched to %q, but %q was requested", route.Path, protocol, requested)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	removeHopByHopHeaders(resp.Header, route.PassThroughHeaders)
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.Header().Set("Connection", "Upgrade")
+	w.Header().Set("Upgrade", protocol)
+	w.WriteHeader(http.StatusSwitchingProtocols)
+
+	conn, readWriter, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		Proxy.Log(logging.LogLevelError, "Response writer failed in ReverseProxyHandler: %v", err)
+		Proxy.Log(logging.LogLevelError, "Failed to take over the connection for %s on route %s: %v", protocol, route.Path, err)
 		return
 	}
 
-	Proxy.Log(logging.LogLevelDebug, "Reverse proxy handler finished")
+	Proxy.Log(logging.LogLevelInfo, "Switched to %s on route %s", protocol, route.Path)
+	tunnel(route, conn, readWriter.Reader, upstream, protocol)
 }
 
 func Http2IntermediateHandler(reader io.Reader, essential *structs.ParsingEssential, respEssential structs.ResponseEssential) {
@@ -212,16 +417,33 @@ func Http2IntermediateHandler(reader io.Reader, essential *structs.ParsingEssent
 func HandleStreamMultiplexing(reader *bufio.Reader, essential *structs.ParsingEssential, respEssential structs.ResponseEssential) error {
 	Proxy.Log(logging.LogLevelInfo, "Starting stream multiplexing")
 
+	activity := newHTTP2Activity(essential.Conn, Proxy.GetTimeouts())
+	var lastStreamID uint32
+
 	for {
 		f, err := frame.ParseFrame(reader)
 		if errors.Is(err, io.EOF) {
 			return nil
 		}
+		if isTimeout(err) {
+			if !activity.idle() {
+				return fmt.Errorf("timed out reading request body: %w", err)
+			}
+
+			// No stream is left that the frame writer could be used by
+			Proxy.Log(logging.LogLevelDebug, "Closing idle HTTP/2 connection to %v", essential.Conn.RemoteAddr())
+			http2Response.SendGoAway(respEssential, lastStreamID, http2Response.ERROR_CODE_NO_ERROR)
+			close(respEssential.FrameChan)
+			<-respEssential.Sent
+			return nil
+		}
 		if err != nil {
 			Proxy.Log(logging.LogLevelError, "Cannot parse frame data: %v", err)
 			return fmt.Errorf("cannot parse frame data: %v", err)
 		}
 
+		activity.frameRead()
+
 		if f.StreamID == 0 {
 			Proxy.Log(logging.LogLevelDebug, "Skipping frame with StreamID 0")
 			continue
@@ -232,6 +454,7 @@ func HandleStreamMultiplexing(reader *bufio.Reader, essential *structs.ParsingEs
 		if _, exists := essential.Channels[f.StreamID]; !exists {
 			Proxy.Log(logging.LogLevelDebug, "Creating new channel for StreamID: %d", f.StreamID)
 			comm := structs.NewCommunication(essential.Dec, essential.Mutex)
+			comm.Received = activity.requestReceived
 
 			essential.Channels[f.StreamID] = comm
 			newChannel = true
@@ -239,7 +462,12 @@ func HandleStreamMultiplexing(reader *bufio.Reader, essential *structs.ParsingEs
 
 		if newChannel {
 			Proxy.Log(logging.LogLevelDebug, "Launching handler for new channel StreamID: %d", f.StreamID)
-			go http2.HandleMultiplexedFrameParsing(essential.Channels[f.StreamID], essential.Router, essential.Conn, respEssential)
+			lastStreamID = max(lastStreamID, f.StreamID)
+			activity.streamOpened()
+			go func(comm *structs.Communication) {
+				defer activity.streamClosed()
+				http2.HandleMultiplexedFrameParsing(comm, essential.Router, essential.Conn, respEssential, essential.Limits)
+			}(essential.Channels[f.StreamID])
 		}
 
 		select {
@@ -267,11 +495,15 @@ func HandleAccept(conn net.Conn, r chi.Router) {
 	tlsConn, ok := conn.(*tls.Conn)
 	if ok {
 		Proxy.Log(logging.LogLevelDebug, "Performing TLS handshake with %v", conn.RemoteAddr())
+		if timeout := Proxy.GetTimeouts().Handshake; timeout > 0 {
+			_ = tlsConn.SetDeadline(time.Now().Add(timeout))
+		}
 		err := tlsConn.Handshake()
 		if err != nil {
 			Proxy.Log(logging.LogLevelError, "TLS handshake failed with %v: %v", conn.RemoteAddr(), err)
 			return
 		}
+		_ = tlsConn.SetDeadline(time.Time{})
 	}
 
 	if !ok || tlsConn.ConnectionState().NegotiatedProtocol == "http/1.1" {
@@ -283,39 +515,177 @@ func HandleAccept(conn net.Conn, r chi.Router) {
 	Proxy.Log(logging.LogLevelInfo, "Handled connection from %v", conn.RemoteAddr())
 }
 
+// closeSafely half-closes conn and discards what the client is still sending for
+// a moment, so the final response isn't lost to a TCP reset.
+func closeSafely(conn net.Conn) {
+	type closeWriter interface {
+		CloseWrite() error
+	}
+
+	if cw, ok := conn.(closeWriter); ok {
+		_ = cw.CloseWrite()
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	_, _ = io.Copy(io.Discard, io.LimitReader(conn, lingerMaxBytes))
+}
+
+// rejectHTTP11 answers a request that couldn't be parsed and closes the connection.
+func rejectHTTP11(conn net.Conn, statusCode int) {
+	responseWriter := http11Response.NewResponse(conn)
+	responseWriter.SetOptions(Proxy.GetResponseOptions())
+	responseWriter.Header().Set("Connection", "close")
+	responseWriter.Header().Set("Content-Length", "0")
+	responseWriter.WriteHeader(statusCode)
+	_ = responseWriter.Finish()
+	closeSafely(conn)
+}
+
 func HandleHTTP11(conn net.Conn, r chi.Router) {
 	proxy := Proxy // Use global Proxy
-	requestReader := bufio.NewReader(conn)
-	sendBadRequest := false
+	timeouts := proxy.GetTimeouts()
+	conn = withWriteTimeout(conn, timeouts.Write)
+	reads := &idleReadConn{Conn: conn}
+	requestReader := bufio.NewReader(reads)
 	moreRequests := false
 	var req *http.Request
 	var err error
 
-	for {
-		// TODO: Turn into a go routine in order
-		req, err, moreRequests = http11.Parser(requestReader)
+	pipe := newPipeline(conn, proxy.GetMaxPipelinedRequests())
+	defer pipe.wait()
+
+	for first := true; ; first = false {
+		if pipe.isClosing() {
+			return
+		}
+
+		reads.setIdleTimeout(0)
+		if err := awaitRequest(conn, requestReader, timeouts, first); err != nil {
+			if isTimeout(err) {
+				proxy.Log(logging.LogLevelDebug, "Closing idle connection to %v", conn.RemoteAddr())
+			}
+			return
+		}
+
+		req, err, moreRequests = http11.Parser(requestReader, proxy.GetParserOptions())
 		if err != nil {
+			// Error responses go out after everything still in flight
+			pipe.wait()
+			if pipe.isClosing() {
+				return
+			}
+
+			var parseErr *http11.ParseError
+			if errors.As(err, &parseErr) {
+				proxy.Log(logging.LogLevelWarn, "[%s] Rejected request from %v: %v", strings.ToUpper(http.StatusText(parseErr.StatusCode)), conn.RemoteAddr(), err)
+				rejectHTTP11(conn, parseErr.StatusCode)
+				return
+			}
 			if strings.Contains(err.Error(), "EOF") {
 				proxy.Log(logging.LogLevelDebug, "Client %v closed the connection", conn.RemoteAddr())
 				return
 			}
-			if errors.Is(err, http11.ChunkEncodingError) {
-				sendBadRequest = true
-				proxy.Log(logging.LogLevelWarn, "Chunk encoding error for %v: %v", conn.RemoteAddr(), err)
-			} else {
-				proxy.Log(logging.LogLevelError, "Failed to parse request from %v: %v", conn.RemoteAddr(), err)
+			if isTimeout(err) {
+				proxy.Log(logging.LogLevelWarn, "Timed out reading request header from %v", conn.RemoteAddr())
 				return
 			}
+			proxy.Log(logging.LogLevelError, "Failed to parse request from %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+
+		if http11.HasUnsupportedExpectation(req) {
+			pipe.wait()
+			proxy.Log(logging.LogLevelWarn, "[EXPECTATION FAILED] Unsupported expectation %q from %v", req.Header.Get("Expect"), conn.RemoteAddr())
+			rejectHTTP11(conn, http.StatusExpectationFailed)
+			return
 		}
 
 		req.RemoteAddr = conn.RemoteAddr().String()
-		responseWriter := http11Response.NewResponse(conn)
-		if sendBadRequest {
-			responseWriter.WriteHeader(http.StatusBadRequest)
-			proxy.Log(logging.LogLevelWarn, "[BAD REQUEST] Chunked encoding issue for %v", conn.RemoteAddr())
-		} else {
-			proxy.Log(logging.LogLevelDebug, "Serving HTTP/1.1 request from %v", conn.RemoteAddr())
-			r.ServeHTTP(responseWriter, req)
+		// The body may pause for ReadBody at most, whoever reads it
+		setReadDeadline(conn, timeouts.ReadBody)
+		if req.Body != http.NoBody {
+			reads.setIdleTimeout(timeouts.ReadBody)
+		}
+
+		// Safe requests without a body don't hold up the connection,
+		// everything else is read from the connection and served in turn
+		upgrade := isUpgradeRequest(req.Header)
+		concurrent := !upgrade && req.Body == http.NoBody && isSafe(req.Method)
+		if !concurrent && (upgrade || !isSafe(req.Method)) {
+			pipe.wait()
+		}
+
+		slot := pipe.reserve()
+		responseWriter := http11Response.NewResponse(slot)
+		responseWriter.SetRequest(req)
+		responseWriter.SetOptions(proxy.GetResponseOptions())
+		if upgrade {
+			// Only a request nothing else runs alongside may take over the connection
+			responseWriter.SetReader(requestReader)
+		}
+
+		if concurrent {
+			proxy.Log(logging.LogLevelDebug, "Serving pipelined HTTP/1.1 request from %v", conn.RemoteAddr())
+			go func(req *http.Request) {
+				r.ServeHTTP(responseWriter, req)
+				if err := responseWriter.Finish(); err != nil {
+					proxy.Log(logging.LogLevelWarn, "Failed to finish response to %v: %v", req.RemoteAddr, err)
+				}
+				slot.finish(responseWriter.Header().Get("Connection") == "close")
+			}(req)
+
+			if !moreRequests {
+				break
+			}
+			continue
+		}
+
+		var continueBody *continueReader
+		if http11.ExpectsContinue(req) && req.Body != http.NoBody {
+			continueBody = newContinueReader(req.Body, responseWriter)
+			req.Body = continueBody
+		}
+
+		proxy.Log(logging.LogLevelDebug, "Serving HTTP/1.1 request from %v", conn.RemoteAddr())
+		r.ServeHTTP(responseWriter, req)
+		if responseWriter.Hijacked() {
+			slot.finish(false)
+			proxy.Log(logging.LogLevelDebug, "Connection to %v was taken over by the handler", conn.RemoteAddr())
+			return
+		}
+		// A client that couldn't take the response isn't sent anything further
+		closeConnection := false
+		if err := responseWriter.Finish(); err != nil {
+			proxy.Log(logging.LogLevelWarn, "Failed to finish response to %v: %v", conn.RemoteAddr(), err)
+			closeConnection = true
+		}
+
+		closeConnection = closeConnection || responseWriter.Header().Get("Connection") == "close"
+		if continueBody != nil {
+			req.Body = continueBody.body
+			// The client may still be holding the body back, draining it could block forever
+			if !continueBody.sent {
+				proxy.Log(logging.LogLevelDebug, "Closing connection to %v, the expected body was never requested", conn.RemoteAddr())
+				closeConnection = true
+			}
+		}
+
+		slot.finish(closeConnection)
+		if closeConnection {
+			proxy.Log(logging.LogLevelDebug, "Closing connection to %v as requested by the handler", conn.RemoteAddr())
+			pipe.wait()
+			closeSafely(conn)
+			return
+		}
+
+		// Skip whatever the handler left of the body so the next request starts at the right byte
+		if req.Body != nil {
+			err = http11.DrainBody(req)
+			_ = req.Body.Close()
+			if err != nil {
+				proxy.Log(logging.LogLevelWarn, "Failed to drain request body from %v: %v", conn.RemoteAddr(), err)
+				return
+			}
 		}
 
 		if !moreRequests {
@@ -329,14 +699,19 @@ func HandleHTTP2(tlsConn *tls.Conn, r chi.Router) {
 	requestReader := bufio.NewReader(tlsConn)
 	dec := hpack.NewDecoder()
 
+	timeouts := proxy.GetTimeouts()
+	conn := withWriteTimeout(tlsConn, timeouts.Write)
+
 	// Validate settings frame
+	setReadDeadline(tlsConn, timeouts.ReadHeader)
 	err := http2Response.VerifyConnectionPreface(requestReader)
 	if err != nil {
 		proxy.Log(logging.LogLevelError, "Failed to verify connection preface for %v: %v", tlsConn.RemoteAddr(), err)
 		return
 	}
 
-	err = http2Response.SendSettingsFrame(tlsConn)
+	limits := proxy.GetLimits()
+	err = http2Response.SendSettingsFrame(conn, uint32(limits.MaxHeaderBytes))
 	if err != nil {
 		proxy.Log(logging.LogLevelError, "Failed to send settings frame for %v: %v", tlsConn.RemoteAddr(), err)
 		return
@@ -344,8 +719,14 @@ func HandleHTTP2(tlsConn *tls.Conn, r chi.Router) {
 
 	proxy.Log(logging.LogLevelDebug, "Established HTTP/2 connection with %v", tlsConn.RemoteAddr())
 
-	respEssential := structs.NewResponseEssential(tlsConn, hpack.NewEncoder(4096))
+	respEssential := structs.NewResponseEssential(conn, hpack.NewEncoder(4096), proxy.GetResponseOptions(), proxy)
 	go http2Response.SendFrames(*respEssential)
 
-	Http2IntermediateHandler(requestReader, structs.NewParsingEssential(dec, new(sync.Mutex), r, tlsConn), *respEssential)
+	streamLimits := structs.StreamLimits{
+		MaxHeaderListSize: limits.MaxHeaderBytes,
+		MaxURILength:      limits.MaxURILength,
+		MaxBodyBytes:      limits.MaxBodyBytes,
+		CheckRoute:        checkRoute,
+	}
+	Http2IntermediateHandler(requestReader, structs.NewParsingEssential(dec, new(sync.Mutex), r, tlsConn, streamLimits), *respEssential)
 }
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopByHopHeaders only apply to a single connection and are never forwarded (RFC 9110 Section 7.6.1).
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHopHeaders deletes the hop-by-hop fields and every field named in
+// Connection from header, except the ones in passThrough (canonical names).
+func removeHopByHopHeaders(header http.Header, passThrough map[string]bool) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" && !passThrough[name] {
+				header.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		if !passThrough[http.CanonicalHeaderKey(name)] {
+			header.Del(name)
+		}
+	}
+}
+
+// acceptsTrailers reports whether the client announced with TE that it accepts
+// trailer fields, which is passed on to the upstream.
+func acceptsTrailers(header http.Header) bool {
+	for _, value := range header.Values("TE") {
+		for _, coding := range strings.Split(value, ",") {
+			coding, _, _ = strings.Cut(coding, ";")
+			if strings.EqualFold(strings.TrimSpace(coding), "trailers") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// maxBufferedResponse is how much of a response waiting for the ones before it
+// is buffered. Its handler then blocks until it is the next to be sent, which
+// in turn stops further requests from being read once all slots are taken.
+const maxBufferedResponse = 64 << 10
+
+// pipeline serves the pipelined requests of one HTTP/1.1 connection
+// concurrently and writes their responses back in request order.
+type pipeline struct {
+	conn     net.Conn
+	mutex    sync.Mutex
+	advanced *sync.Cond      // Signalled when the head of slots changes or the connection closes
+	slots    []*pipelineSlot // Responses not sent completely yet, in request order
+	capacity chan struct{}
+	inFlight sync.WaitGroup
+	closing  bool
+}
+
+// pipelineSlot is the connection as seen by the response of one request.
+// Everything written before the preceding responses are complete is buffered.
+type pipelineSlot struct {
+	net.Conn
+	pipeline        *pipeline
+	buffer          bytes.Buffer
+	done            bool
+	closeConnection bool
+}
+
+func newPipeline(conn net.Conn, maxConcurrent int) *pipeline {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+
+	p := &pipeline{
+		conn:     conn,
+		capacity: make(chan struct{}, maxConcurrent),
+	}
+	p.advanced = sync.NewCond(&p.mutex)
+
+	return p
+}
+
+// isSafe reports the methods that may run concurrently with other requests of
+// the same connection (RFC 9112 Section 9.3.2). Idempotent methods like DELETE
+// still change state, requests after them have to see the change.
+func isSafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
+
+// reserve queues the response of the next request, blocking while the
+// connection already has its maximum of requests in flight.
+func (p *pipeline) reserve() *pipelineSlot {
+	p.capacity <- struct{}{}
+	p.inFlight.Add(1)
+
+	slot := &pipelineSlot{Conn: p.conn, pipeline: p}
+
+	p.mutex.Lock()
+	p.slots = append(p.slots, slot)
+	p.mutex.Unlock()
+
+	return slot
+}
+
+// wait blocks until every reserved response has been handled.
+func (p *pipeline) wait() {
+	p.inFlight.Wait()
+}
+
+// isClosing reports whether a response asked for the connection to be closed.
+func (p *pipeline) isClosing() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.closing
+}
+
+func (s *pipelineSlot) Write(data []byte) (int, error) {
+	p := s.pipeline
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	written := 0
+	for {
+		if p.closing {
+			return written, net.ErrClosed
+		}
+		if p.slots[0] == s {
+			n, err := p.conn.Write(data)
+			return written + n, err
+		}
+
+		if room := maxBufferedResponse - s.buffer.Len(); room > 0 {
+			n, _ := s.buffer.Write(data[:min(room, len(data))])
+			written += n
+			data = data[n:]
+			if len(data) == 0 {
+				return written, nil
+			}
+		}
+		p.advanced.Wait()
+	}
+}
+
+// finish marks the response complete and hands the connection on to the
+// responses queued behind it. Responses after one closing the connection are dropped.
+func (s *pipelineSlot) finish(closeConnection bool) {
+	p := s.pipeline
+	p.mutex.Lock()
+
+	s.done = true
+	s.closeConnection = closeConnection
+
+	for len(p.slots) > 0 && p.slots[0].done && !p.closing {
+		head := p.slots[0]
+		p.slots = p.slots[1:]
+
+		if head.closeConnection {
+			p.closing = true
+			// Let the client see the end of the stream, the read loop then stops on EOF
+			if cw, ok := p.conn.(interface{ CloseWrite() error }); ok {
+				_ = cw.CloseWrite()
+			}
+			break
+		}
+
+		if len(p.slots) > 0 {
+			_, _ = p.conn.Write(p.slots[0].buffer.Bytes())
+			p.slots[0].buffer.Reset()
+		}
+	}
+
+	p.advanced.Broadcast()
+	p.mutex.Unlock()
+
+	<-p.capacity
+	p.inFlight.Done()
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"httpServer/internal/health"
+	"httpServer/internal/logging"
+	proxystructs "httpServer/internal/reverseproxy/structs"
+)
+
+// ACMEChallengePrefix is where ACME HTTP-01 validation requests arrive (RFC 8555 Section 8.3).
+const ACMEChallengePrefix = "/.well-known/acme-challenge/"
+
+// httpsURL returns the address of r on the TLS listener, false if r names no
+// host to redirect to.
+func httpsURL(r *http.Request, tlsPort uint16) (string, bool) {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if host == "" {
+		return "", false
+	}
+	if tlsPort != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(int(tlsPort)))
+	}
+
+	return "https://" + host + r.URL.RequestURI(), true
+}
+
+// RedirectHandler sends requests on the plain HTTP listener to HTTPS, with the
+// redirect status of their route or the listener's default.
+func RedirectHandler(w http.ResponseWriter, r *http.Request) {
+	target, ok := httpsURL(r, Proxy.GetPort())
+	if !ok {
+		Proxy.Log(logging.LogLevelWarn, "Rejected redirect of %s %s without a host", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	statusCode := Proxy.GetPlainHTTP().RedirectStatus
+	if route, _ := resolveRoute(Proxy.GetRoutes(), r.Host, requestPath(r.URL)); route != nil && route.RedirectStatus != 0 {
+		statusCode = route.RedirectStatus
+	}
+
+	Proxy.Log(logging.LogLevelDebug, "Redirecting %s %s to %s", r.Method, r.URL.Path, target)
+
+	w.Header().Set("Location", target)
+	w.WriteHeader(statusCode)
+}
+
+// ACMEChallengeHandler passes ACME HTTP-01 challenges on to the configured
+// backend, so certificates can be issued while everything else is redirected.
+func ACMEChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	plainHTTP := Proxy.GetPlainHTTP()
+	path := requestPath(r.URL)
+	if plainHTTP.ACMEChallengeHost == nil || !strings.HasPrefix(path, ACMEChallengePrefix) {
+		NotFoundHandler(w, r)
+		return
+	}
+
+	forward(w, r, &proxystructs.ProxyRoute{
+		Path:       ACMEChallengePrefix,
+		Host:       plainHTTP.ACMEChallengeHost,
+		TargetPath: path,
+		Limits:     Proxy.GetLimits(),
+	}, path)
+}
+
+// PlainHTTPHandler serves routes configured for plain HTTP and redirects all
+// other requests to HTTPS.
+func PlainHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	if route, _ := resolveRoute(Proxy.GetRoutes(), r.Host, requestPath(r.URL)); route != nil && route.ServePlainHTTP {
+		ReverseProxyHandler(w, r)
+		return
+	}
+
+	RedirectHandler(w, r)
+}
+
+// UpstreamHealthHandler answers with the health of every checked upstream as JSON.
+func UpstreamHealthHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := Proxy.UpstreamHealth()
+	if statuses == nil {
+		statuses = []health.Status{}
+	}
+
+	body, err := json.Marshal(statuses)
+	if err != nil {
+		Proxy.Log(logging.LogLevelError, "Failed to encode upstream health: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"httpServer/internal/logging"
+	proxystructs "httpServer/internal/reverseproxy/structs"
+)
+
+// retrier decides whether a failed attempt of a request is sent again.
+type retrier struct {
+	route      *proxystructs.ProxyRoute
+	idempotent bool
+	// replayable is false once the body can't be sent a second time
+	replayable bool
+	retries    int
+	// holding is set while the request holds a retry of the circuit breaker
+	holding bool
+}
+
+func newRetrier(route *proxystructs.ProxyRoute, r *http.Request, replayable bool) *retrier {
+	if route.Retries != nil {
+		route.Retries.Budget.Deposit()
+	}
+
+	return &retrier{
+		route:      route,
+		idempotent: isIdempotent(r.Method),
+		replayable: replayable,
+	}
+}
+
+// retry reports whether the attempt that ended with resp or err is sent again,
+// after waiting for the backoff.
+func (rt *retrier) retry(ctx context.Context, resp *http.Response, err error) bool {
+	policy := rt.route.Retries
+	if policy == nil || !rt.replayable || rt.retries >= policy.Attempts || !policy.Retryable(rt.idempotent, resp, err) {
+		return false
+	}
+
+	if !rt.holding {
+		if !rt.route.Breaker.AcquireRetry() {
+			Proxy.Log(logging.LogLevelWarn, "Not retrying on route %s, the circuit breaker allows no more retries", rt.route.Path)
+			return false
+		}
+		rt.holding = true
+	}
+	if !policy.Budget.Withdraw() {
+		Proxy.Log(logging.LogLevelWarn, "Not retrying on route %s, the retry budget is used up", rt.route.Path)
+		return false
+	}
+
+	backoff := policy.Backoff(rt.retries)
+	rt.retries++
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// done gives back what the retries of the request held.
+func (rt *retrier) done() {
+	if rt.holding {
+		rt.route.Breaker.ReleaseRetry()
+	}
+}
+
+// isIdempotent reports the methods whose requests may be sent more than once
+// (RFC 9110 Section 9.2.2).
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+// bufferBody reads up to limit bytes of body so it can be sent more than once.
+// If body is larger than limit it returns a reader for all of it instead.
+func bufferBody(body io.ReadCloser, limit int64) (buffered []byte, rest io.ReadCloser, err error) {
+	buffered, err = io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(buffered)) <= limit {
+		return buffered, nil, nil
+	}
+
+	return nil, struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buffered), body), body}, nil
+}
+
+// discard drops the answer of an attempt that is retried.
+func discard(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	_ = resp.Body.Close()
+}
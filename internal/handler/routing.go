@@ -0,0 +1,334 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"httpServer/internal/balancer"
+	proxystructs "httpServer/internal/reverseproxy/structs"
+)
+
+// resolveRoute finds the route of a request for host and path, see requestPath,
+// and the escaped path it has on the upstream. The routes of the most specific server for host are
+// considered, see routesForHost. Among those exact routes win over glob and
+// regex routes, which are tried in configuration order, and those win over
+// prefix routes, of which the longest matching one is used.
+func resolveRoute(routes []proxystructs.ProxyRoute, host string, path string) (*proxystructs.ProxyRoute, string) {
+	routes = routesForHost(routes, host)
+
+	for i := range routes {
+		if routes[i].Match == proxystructs.MatchExact && routes[i].Path == path {
+			return &routes[i], routes[i].TargetPath
+		}
+	}
+
+	for i := range routes {
+		route := &routes[i]
+		if route.Match != proxystructs.MatchGlob && route.Match != proxystructs.MatchRegex || route.Pattern == nil {
+			continue
+		}
+
+		if match := route.Pattern.FindStringSubmatchIndex(path); match != nil {
+			return route, string(route.Pattern.ExpandString(nil, route.TargetPath, path, match))
+		}
+	}
+
+	var longest *proxystructs.ProxyRoute
+	for i := range routes {
+		route := &routes[i]
+		if route.Match == proxystructs.MatchPrefix && hasPathPrefix(path, route.Path) && (longest == nil || len(route.Path) > len(longest.Path)) {
+			longest = route
+		}
+	}
+	if longest != nil {
+		return longest, joinPath(longest.TargetPath, path[len(longest.Path):])
+	}
+
+	return nil, ""
+}
+
+// requestPath is the path of u routes are matched against: escaped like the
+// client sent it, so %2F stays within its segment, with unreserved characters
+// decoded and without dot segments.
+func requestPath(u *url.URL) string {
+	return removeDotSegments(normalizeEscapes(u.EscapedPath()))
+}
+
+// normalizeEscapes decodes percent-encoded unreserved characters and writes the
+// remaining escapes in upper case (RFC 3986 Section 6.2.2).
+func normalizeEscapes(path string) string {
+	if !strings.Contains(path, "%") {
+		return path
+	}
+
+	var normalized strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' || i+2 >= len(path) {
+			normalized.WriteByte(path[i])
+			continue
+		}
+
+		decoded, err := strconv.ParseUint(path[i+1:i+3], 16, 8)
+		if err != nil {
+			normalized.WriteByte(path[i])
+			continue
+		}
+		if c := byte(decoded); isUnreserved(c) {
+			normalized.WriteByte(c)
+		} else {
+			normalized.WriteString(strings.ToUpper(path[i : i+3]))
+		}
+		i += 2
+	}
+	return normalized.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0
+}
+
+// removeDotSegments resolves the . and .. segments of path (RFC 3986 Section
+// 5.2.4), it never climbs above the root.
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	cleaned := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		switch segment {
+		case ".":
+		case "..":
+			if len(cleaned) > 1 {
+				cleaned = cleaned[:len(cleaned)-1]
+			}
+		default:
+			cleaned = append(cleaned, segment)
+			continue
+		}
+		// /a/b/.. is the directory /a/
+		if i == len(segments)-1 {
+			cleaned = append(cleaned, "")
+		}
+	}
+	return strings.Join(cleaned, "/")
+}
+
+// withinTarget reports whether path stays below the target path of route it
+// was built from: it starts with the fixed part of the target path and has no
+// dot segments an upstream would resolve.
+func withinTarget(path, target string) bool {
+	path, _, _ = strings.Cut(path, "?")
+	target, _, _ = strings.Cut(target, "?")
+	if fixed, _, expands := strings.Cut(target, "$"); expands {
+		target = fixed
+	}
+
+	return strings.HasPrefix(path, target) && removeDotSegments(normalizeEscapes(path)) == normalizeEscapes(path)
+}
+
+// normalizeHost strips the port and a trailing dot from a Host header value.
+func normalizeHost(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// hostRank tells how specifically pattern names host: 0 if it doesn't match,
+// higher for longer wildcard suffixes and highest for exact names.
+func hostRank(pattern string, host string) int {
+	if suffix, wildcard := strings.CutPrefix(pattern, "*"); wildcard {
+		if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+			return len(suffix)
+		}
+		return 0
+	}
+
+	if pattern == host {
+		return math.MaxInt
+	}
+	return 0
+}
+
+// routesForHost picks the server a request for host goes to, like virtual
+// hosts: the routes naming host exactly, otherwise those with the longest
+// matching wildcard, otherwise the routes without hosts (the default server).
+func routesForHost(routes []proxystructs.ProxyRoute, host string) []proxystructs.ProxyRoute {
+	host = normalizeHost(host)
+
+	best := 0
+	ranks := make([]int, len(routes))
+	for i, route := range routes {
+		for _, pattern := range route.Hosts {
+			ranks[i] = max(ranks[i], hostRank(pattern, host))
+		}
+		best = max(best, ranks[i])
+	}
+
+	var server []proxystructs.ProxyRoute
+	for i, route := range routes {
+		if best > 0 && ranks[i] == best || best == 0 && len(route.Hosts) == 0 {
+			server = append(server, route)
+		}
+	}
+
+	return server
+}
+
+// hasPathPrefix reports whether prefix covers path up to a segment boundary,
+// /api matches /api and /api/users but not /apis.
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// joinPath appends the unmatched rest of a request path to the target path of its route.
+func joinPath(target, rest string) string {
+	if rest == "" {
+		return target
+	}
+
+	switch {
+	case strings.HasSuffix(target, "/") && strings.HasPrefix(rest, "/"):
+		return target + rest[1:]
+	case !strings.HasSuffix(target, "/") && !strings.HasPrefix(rest, "/"):
+		return target + "/" + rest
+	}
+	return target + rest
+}
+
+// pickUpstream chooses the upstream of route that serves r among its available
+// upstreams, nil if all of them are unhealthy or ejected. Upstreams that were
+// tried already are only picked again if no other one is left.
+func pickUpstream(route *proxystructs.ProxyRoute, r *http.Request, tried []*balancer.Upstream) *balancer.Upstream {
+	if len(route.Upstreams) == 0 {
+		return balancer.NewUpstream(route.Host, 1)
+	}
+
+	upstreams := availableUpstreams(route.Upstreams)
+	if untried := untriedUpstreams(upstreams, tried); len(untried) > 0 {
+		upstreams = untried
+	}
+	switch {
+	case len(upstreams) == 0:
+		return nil
+	case len(upstreams) == 1 || route.Balancer == nil:
+		return upstreams[0]
+	}
+
+	return route.Balancer.Pick(upstreams, r)
+}
+
+// availableUpstreams returns the upstreams in rotation, upstreams itself if all of them are.
+func availableUpstreams(upstreams []*balancer.Upstream) []*balancer.Upstream {
+	for i, upstream := range upstreams {
+		if upstream.Available() {
+			continue
+		}
+
+		available := append([]*balancer.Upstream{}, upstreams[:i]...)
+		for _, upstream := range upstreams[i+1:] {
+			if upstream.Available() {
+				available = append(available, upstream)
+			}
+		}
+		return available
+	}
+
+	return upstreams
+}
+
+// untriedUpstreams returns the upstreams that aren't in tried.
+func untriedUpstreams(upstreams, tried []*balancer.Upstream) []*balancer.Upstream {
+	if len(tried) == 0 {
+		return upstreams
+	}
+
+	var untried []*balancer.Upstream
+	for _, upstream := range upstreams {
+		if !slices.Contains(tried, upstream) {
+			untried = append(untried, upstream)
+		}
+	}
+	return untried
+}
+
+// reportOutcome tells the outlier detection of route whether the request to upstream failed.
+func reportOutcome(route *proxystructs.ProxyRoute, upstream *balancer.Upstream, failed bool) {
+	if route.Outliers != nil {
+		route.Outliers.Report(upstream, failed)
+	}
+}
+
+// trackedBody remembers whether reading the body of the client failed, so failed
+// uploads aren't held against the upstream. The transport reads it from a
+// goroutine of its own.
+type trackedBody struct {
+	io.ReadCloser
+	failed atomic.Bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.failed.Store(true)
+	}
+	return n, err
+}
+
+// clientBodyFailed reports whether a request failed because of the body of the
+// client, which is too large or couldn't be read.
+func clientBodyFailed(err error, body *trackedBody) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr) || (body != nil && body.failed.Load())
+}
+
+// upstreamURL is where a request for targetPath is sent on host. targetPath is
+// escaped and used as it is, a query in it comes first, the query of the
+// client is appended to it.
+func upstreamURL(host *url.URL, targetPath string, rawQuery string) *url.URL {
+	targetPath, targetQuery, _ := strings.Cut(targetPath, "?")
+
+	target := *host
+	target.RawPath = targetPath
+	target.Path = targetPath
+	if path, err := url.PathUnescape(targetPath); err == nil {
+		target.Path = path
+	}
+	target.Fragment, target.RawFragment = "", ""
+	switch {
+	case targetQuery == "":
+		target.RawQuery = rawQuery
+	case rawQuery == "":
+		target.RawQuery = targetQuery
+	default:
+		target.RawQuery = targetQuery + "&" + rawQuery
+	}
+
+	return &target
+}
+
+// hostHeader returns the Host sent to the upstream of route, empty for the host of its URL.
+func hostHeader(route *proxystructs.ProxyRoute, r *http.Request) string {
+	switch route.HostHeader {
+	case proxystructs.HostHeaderPreserve:
+		return r.Host
+	case proxystructs.HostHeaderFixed:
+		return route.FixedHost
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// aborter is implemented by response writers that can signal a body that was cut short.
+type aborter interface {
+	Abort()
+}
+
+// flushIntervalFor returns how often a streamed response is flushed: a negative
+// interval flushes after every write, zero leaves it to the writer's buffer.
+// Event streams and bodies of unknown length are flushed right away unless the route says otherwise.
+func flushIntervalFor(resp *http.Response, configured time.Duration) time.Duration {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		return -1
+	}
+	if configured == 0 && resp.ContentLength == -1 {
+		return -1
+	}
+
+	return configured
+}
+
+// latencyWriter flushes at most interval after a write, so a slow trickle of
+// data doesn't sit in the buffer.
+type latencyWriter struct {
+	mutex    sync.Mutex
+	dst      io.Writer
+	flusher  http.Flusher
+	interval time.Duration
+	timer    *time.Timer
+	pending  bool
+}
+
+func (w *latencyWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	n, err := w.dst.Write(p)
+	if w.interval < 0 {
+		w.flusher.Flush()
+		return n, err
+	}
+
+	if !w.pending {
+		w.pending = true
+		if w.timer == nil {
+			w.timer = time.AfterFunc(w.interval, w.flush)
+		} else {
+			w.timer.Reset(w.interval)
+		}
+	}
+
+	return n, err
+}
+
+func (w *latencyWriter) flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.pending {
+		w.flusher.Flush()
+		w.pending = false
+	}
+}
+
+func (w *latencyWriter) stop() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.pending = false
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+// copyResponse streams body to w as it arrives, flushing according to interval.
+func copyResponse(w http.ResponseWriter, body io.Reader, interval time.Duration) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok || interval == 0 {
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	writer := &latencyWriter{dst: w, flusher: flusher, interval: interval}
+	defer writer.stop()
+
+	_, err := io.Copy(writer, body)
+	return err
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	proxystructs "httpServer/internal/reverseproxy/structs"
+)
+
+// writeTimeoutConn bounds every single write to the client, so a client that
+// stops reading can't hold on to the connection. Long streamed responses stay
+// possible as long as the client keeps up with them.
+type writeTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func withWriteTimeout(conn net.Conn, timeout time.Duration) net.Conn {
+	if timeout <= 0 {
+		return conn
+	}
+
+	return &writeTimeoutConn{Conn: conn, timeout: timeout}
+}
+
+func (c *writeTimeoutConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Write(p)
+}
+
+func (c *writeTimeoutConn) CloseWrite() error {
+	type closeWriter interface {
+		CloseWrite() error
+	}
+
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// setReadDeadline gives the client timeout for its next reads, zero removes the deadline.
+// idleReadConn restarts the read deadline on every read while it has a
+// timeout, so a request body may take as long as it keeps arriving and only a
+// stalled one is cut off.
+type idleReadConn struct {
+	net.Conn
+	timeout atomic.Int64
+}
+
+// setIdleTimeout starts or, with 0, stops restarting the deadline on reads.
+func (c *idleReadConn) setIdleTimeout(timeout time.Duration) {
+	c.timeout.Store(int64(timeout))
+}
+
+func (c *idleReadConn) Read(p []byte) (int, error) {
+	if timeout := time.Duration(c.timeout.Load()); timeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.Conn.Read(p)
+}
+
+func setReadDeadline(conn net.Conn, timeout time.Duration) {
+	if timeout <= 0 {
+		_ = conn.SetReadDeadline(time.Time{})
+		return
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// awaitRequest waits up to the idle timeout for the next request on a kept-alive
+// connection to start, the header section then has to arrive within ReadHeader.
+func awaitRequest(conn net.Conn, reader *bufio.Reader, timeouts proxystructs.Timeouts, first bool) error {
+	if !first && reader.Buffered() == 0 {
+		setReadDeadline(conn, timeouts.Idle)
+		if _, err := reader.Peek(1); err != nil {
+			return err
+		}
+	}
+
+	setReadDeadline(conn, timeouts.ReadHeader)
+	return nil
+}
+
+// http2Activity keeps the read deadline of an HTTP/2 connection in line with its
+// streams. The idle timeout applies while no stream is open, ReadBody while a
+// request is still being received and none while streams wait for their upstream.
+type http2Activity struct {
+	mutex     sync.Mutex
+	conn      net.Conn
+	timeouts  proxystructs.Timeouts
+	open      int
+	receiving int
+}
+
+func newHTTP2Activity(conn net.Conn, timeouts proxystructs.Timeouts) *http2Activity {
+	activity := &http2Activity{conn: conn, timeouts: timeouts}
+	activity.frameRead()
+
+	return activity
+}
+
+func (a *http2Activity) update() {
+	switch {
+	case a.receiving > 0:
+		setReadDeadline(a.conn, a.timeouts.ReadBody)
+	case a.open == 0:
+		setReadDeadline(a.conn, a.timeouts.Idle)
+	default:
+		setReadDeadline(a.conn, 0)
+	}
+}
+
+// frameRead restarts the clock, the client is still active.
+func (a *http2Activity) frameRead() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.update()
+}
+
+func (a *http2Activity) streamOpened() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.open++
+	a.receiving++
+	a.update()
+}
+
+func (a *http2Activity) requestReceived() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.receiving--
+	a.update()
+}
+
+func (a *http2Activity) streamClosed() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.open--
+	a.update()
+}
+
+func (a *http2Activity) idle() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.open == 0
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"httpServer/internal/logging"
+	proxystructs "httpServer/internal/reverseproxy/structs"
+)
+
+// headerHasToken reports whether one of the comma separated values of name in header is token.
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, element := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(element), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isUpgradeRequest reports whether the client asks to switch protocols, e.g. to a WebSocket.
+func isUpgradeRequest(header http.Header) bool {
+	return headerHasToken(header, "Connection", "upgrade") && header.Get("Upgrade") != ""
+}
+
+// idleTimer closes a tunnel once neither direction transferred anything for timeout.
+type idleTimer struct {
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func newIdleTimer(timeout time.Duration, expire func()) *idleTimer {
+	if timeout <= 0 {
+		return &idleTimer{}
+	}
+
+	return &idleTimer{timer: time.AfterFunc(timeout, expire), timeout: timeout}
+}
+
+func (t *idleTimer) reset() {
+	if t.timer != nil {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+func (t *idleTimer) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+// countingWriter counts the bytes passed on and keeps the tunnel from idling out.
+type countingWriter struct {
+	dst   io.Writer
+	count *atomic.Int64
+	idle  *idleTimer
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.dst.Write(p)
+	w.count.Add(int64(n))
+	w.idle.reset()
+	return n, err
+}
+
+// tunnel copies bytes in both directions until one side closes or the tunnel
+// idles out, then closes both sides.
+func tunnel(route *proxystructs.ProxyRoute, client io.ReadWriteCloser, clientReader io.Reader, upstream io.ReadWriteCloser, protocol string) {
+	var toUpstream, toClient atomic.Int64
+	var closeOnce sync.Once
+	closeBoth := func() {
+		closeOnce.Do(func() {
+			_ = client.Close()
+			_ = upstream.Close()
+		})
+	}
+
+	idle := newIdleTimer(route.UpgradeIdleTimeout, func() {
+		Proxy.Log(logging.LogLevelInfo, "Closing idle %s tunnel on route %s", protocol, route.Path)
+		closeBoth()
+	})
+	defer idle.stop()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(countingWriter{dst: upstream, count: &toUpstream, idle: idle}, clientReader)
+		closeBoth()
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(countingWriter{dst: client, count: &toClient, idle: idle}, upstream)
+		closeBoth()
+	}()
+	wg.Wait()
+
+	Proxy.Log(logging.LogLevelInfo, "%s tunnel on route %s closed after %v: %d bytes to upstream, %d bytes to client",
+		protocol, route.Path, time.Since(start).Round(time.Millisecond), toUpstream.Load(), toClient.Load())
+}
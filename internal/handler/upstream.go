@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	proxystructs "httpServer/internal/reverseproxy/structs"
+)
+
+// errBodyIdle cancels a request whose upstream stopped sending its body.
+var errBodyIdle = errors.New("upstream response body went idle")
+
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// errClientDeadline cancels a request once the timeout the client asked for ran out.
+var errClientDeadline = errors.New("timeout requested by the client ran out")
+
+// errPinMismatch fails the handshake with an upstream whose certificate chain has none of the pinned keys.
+var errPinMismatch = errors.New("upstream certificate chain has no pinned public key")
+
+// NewTransport creates the transport a route shares between its requests,
+// pooling connections to its upstreams.
+func NewTransport(route *proxystructs.ProxyRoute) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   route.Timeouts.Connect,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   route.Timeouts.TLSHandshake,
+		ResponseHeaderTimeout: route.Timeouts.ResponseHeader,
+		ExpectContinueTimeout: expectContinueTimeout,
+		MaxIdleConns:          route.Pool.MaxIdle,
+		MaxIdleConnsPerHost:   route.Pool.MaxIdlePerHost,
+		MaxConnsPerHost:       route.Pool.MaxPerHost,
+		IdleConnTimeout:       route.Pool.IdleTimeout,
+		ForceAttemptHTTP2:     route.Pool.PreferHTTP2,
+		TLSClientConfig:       upstreamTLSConfig(route.TLS),
+	}
+	if route.Resolver != nil {
+		transport.DialContext = route.Resolver.DialContext(dialer)
+		// Pooled connections may lead to addresses the upstream moved away from
+		route.Resolver.OnChange(transport.CloseIdleConnections)
+	}
+
+	return transport
+}
+
+// upstreamTLSConfig verifies upstream certificates against RootCAs, the system's if none are set.
+func upstreamTLSConfig(upstreamTLS proxystructs.UpstreamTLS) *tls.Config {
+	config := &tls.Config{
+		RootCAs:            upstreamTLS.RootCAs,
+		Certificates:       upstreamTLS.Certificates,
+		ServerName:         upstreamTLS.ServerName,
+		InsecureSkipVerify: upstreamTLS.InsecureSkipVerify,
+	}
+	if len(upstreamTLS.PinnedSPKI) > 0 {
+		config.VerifyConnection = verifyPins(upstreamTLS.PinnedSPKI, !upstreamTLS.InsecureSkipVerify)
+	}
+	return config
+}
+
+// verifyPins accepts a connection if one of the pinned public keys is in its
+// verified chain. Without verification the certificates sent along prove
+// nothing, only the leaf, whose key the handshake proved, is checked then.
+func verifyPins(pins [][sha256.Size]byte, verified bool) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		var certificates []*x509.Certificate
+		if verified {
+			for _, chain := range state.VerifiedChains {
+				certificates = append(certificates, chain...)
+			}
+		} else if len(state.PeerCertificates) > 0 {
+			certificates = state.PeerCertificates[:1]
+		}
+
+		for _, certificate := range certificates {
+			hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if hash == pin {
+					return nil
+				}
+			}
+		}
+		return errPinMismatch
+	}
+}
+
+// retireRoutes stops looking up the upstreams of routes that are no longer
+// served and drops their pooled connections.
+func retireRoutes(routes []proxystructs.ProxyRoute) {
+	for _, route := range routes {
+		if route.Resolver != nil {
+			route.Resolver.Stop()
+		}
+		if route.Transport != nil {
+			route.Transport.CloseIdleConnections()
+		}
+	}
+}
+
+// startResolvers looks up the upstreams of routes in the background.
+func startResolvers(routes []proxystructs.ProxyRoute) {
+	for _, route := range routes {
+		if route.Resolver != nil {
+			route.Resolver.Start()
+		}
+	}
+}
+
+// requestTimeout returns how long the request may take in total and whether
+// the client chose it. A client can ask for a timeout with Request-Timeout or
+// grpc-timeout, but never for more than the route allows.
+func requestTimeout(r *http.Request, total time.Duration) (time.Duration, bool) {
+	hint, ok := parseGRPCTimeout(r.Header.Get("grpc-timeout"))
+	if !ok {
+		hint, ok = parseRequestTimeout(r.Header.Get("Request-Timeout"))
+	}
+
+	if !ok || (total > 0 && hint >= total) {
+		return total, false
+	}
+	return hint, true
+}
+
+// clientGaveUp reports whether the request ended because of the client: it went
+// away or the timeout it asked for ran out. That isn't held against the upstream.
+func clientGaveUp(r *http.Request, ctx context.Context) bool {
+	return r.Context().Err() != nil || errors.Is(context.Cause(ctx), errClientDeadline)
+}
+
+// parseGRPCTimeout parses a gRPC timeout such as "250m": at most eight digits
+// followed by a unit of hours, minutes, seconds, milli-, micro- or nanoseconds.
+func parseGRPCTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+
+	unit, ok := grpcTimeoutUnits[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	amount, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+	if err != nil || amount == 0 {
+		return 0, false
+	}
+	if amount > uint64(math.MaxInt64/unit) {
+		return math.MaxInt64, true
+	}
+
+	return time.Duration(amount) * unit, true
+}
+
+// parseRequestTimeout parses a Request-Timeout in seconds, fractions are allowed.
+func parseRequestTimeout(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(seconds > 0) || seconds > time.Duration(math.MaxInt64).Seconds() {
+		return 0, false
+	}
+
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// idleTimeoutBody cancels its request once a single read of the upstream body
+// takes longer than timeout, so a stalled upstream can't hold the request open.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelCauseFunc) io.ReadCloser {
+	if timeout <= 0 {
+		return body
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		cancel(errBodyIdle)
+	})
+	timer.Stop()
+
+	return &idleTimeoutBody{ReadCloser: body, timeout: timeout, timer: timer}
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	defer b.timer.Stop()
+
+	return b.ReadCloser.Read(p)
+}
+
+// upstreamTimedOut reports whether a request to the upstream failed because one
+// of the route's timeouts expired.
+func upstreamTimedOut(ctx context.Context, err error) bool {
+	return isTimeout(err) || errors.Is(context.Cause(ctx), errBodyIdle) || errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
@@ -0,0 +1,232 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"httpServer/internal/balancer"
+	"httpServer/internal/logging"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBodyBytes is how much of a response is searched for the expected body.
+const maxBodyBytes = 64 * 1024
+
+// Config is the active health check of the upstreams of a route.
+type Config struct {
+	Path string
+	// ExpectedStatus is the status of a healthy upstream, zero accepts every 2xx and 3xx status.
+	ExpectedStatus int
+	// ExpectedBody has to appear in the response body if it is set.
+	ExpectedBody string
+	Interval     time.Duration
+	Timeout      time.Duration
+	// HealthyThreshold and UnhealthyThreshold are how many checks in a row have to
+	// pass or fail before an upstream is taken back into or out of rotation.
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// Status is the current health of an upstream.
+type Status struct {
+	Route   string `json:"route"`
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+	// Since is when the upstream last changed its health, or when checking began.
+	Since time.Time `json:"since"`
+	// LastError is why the latest check failed, empty if it passed.
+	LastError string `json:"last_error,omitempty"`
+}
+
+type target struct {
+	route    string
+	upstream *balancer.Upstream
+	config   Config
+	client   *http.Client
+
+	// Only touched by the goroutine checking the target
+	successes int
+	failures  int
+
+	// Guarded by the mutex of the checker
+	since     time.Time
+	lastError string
+}
+
+// Checker periodically checks upstreams and takes unhealthy ones out of rotation.
+type Checker struct {
+	logger  logging.Logger
+	targets []*target
+
+	mutex  sync.Mutex
+	cancel context.CancelFunc
+	wait   sync.WaitGroup
+}
+
+func NewChecker(logger logging.Logger) *Checker {
+	return &Checker{logger: logger}
+}
+
+// Add checks upstream of route with config once the checker is started. Checks
+// use the transport of the route, so they verify certificates like its requests.
+func (c *Checker) Add(route string, upstream *balancer.Upstream, config Config, transport http.RoundTripper) {
+	c.targets = append(c.targets, &target{
+		route:    route,
+		upstream: upstream,
+		config:   config,
+		client: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: transport,
+			// A redirect is the answer of the upstream itself
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	})
+}
+
+// Start checks every upstream right away and then at its interval until Stop is called.
+func (c *Checker) Start() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	now := time.Now()
+	for _, t := range c.targets {
+		t.since = now
+		c.wait.Add(1)
+		go c.run(ctx, t)
+	}
+}
+
+// Stop ends checking and waits for checks in progress.
+func (c *Checker) Stop() {
+	c.mutex.Lock()
+	cancel := c.cancel
+	c.cancel = nil
+	c.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+		c.wait.Wait()
+	}
+}
+
+// Status returns the health of every checked upstream.
+func (c *Checker) Status() []Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	statuses := make([]Status, 0, len(c.targets))
+	for _, t := range c.targets {
+		statuses = append(statuses, Status{
+			Route:     t.route,
+			URL:       t.upstream.URL.String(),
+			Healthy:   t.upstream.Healthy(),
+			Since:     t.since,
+			LastError: t.lastError,
+		})
+	}
+	return statuses
+}
+
+func (c *Checker) run(ctx context.Context, t *target) {
+	defer c.wait.Done()
+
+	ticker := time.NewTicker(t.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := Probe(ctx, t.client, t.upstream.URL, t.config)
+		if ctx.Err() != nil {
+			// Checks cancelled by Stop say nothing about the upstream
+			return
+		}
+		c.record(t, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// record counts the result of a check and changes the health of the upstream once a threshold is reached.
+func (c *Checker) record(t *target, err error) {
+	wasHealthy := t.upstream.Healthy()
+	var healthy bool
+	if err == nil {
+		t.successes++
+		t.failures = 0
+		healthy = wasHealthy || t.successes >= t.config.HealthyThreshold
+	} else {
+		t.failures++
+		t.successes = 0
+		healthy = wasHealthy && t.failures < t.config.UnhealthyThreshold
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t.lastError = ""
+	if err != nil {
+		t.lastError = err.Error()
+	}
+	if healthy == wasHealthy {
+		return
+	}
+
+	t.upstream.SetHealthy(healthy)
+	t.since = time.Now()
+	if healthy {
+		c.logger.Log(logging.LogLevelInfo, "Upstream %s of route %s is healthy again after %d passed checks", t.upstream.URL, t.route, t.successes)
+	} else {
+		c.logger.Log(logging.LogLevelWarn, "Upstream %s of route %s is unhealthy after %d failed checks, taking it out of rotation: %v", t.upstream.URL, t.route, t.failures, err)
+	}
+}
+
+// Probe sends one health check to the upstream at base and returns why it failed.
+func Probe(ctx context.Context, client *http.Client, base *url.URL, config Config) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base.ResolveReference(&url.URL{Path: config.Path}).String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Close = true
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if config.ExpectedStatus != 0 && resp.StatusCode != config.ExpectedStatus {
+		return fmt.Errorf("status %d instead of %d", resp.StatusCode, config.ExpectedStatus)
+	}
+	if config.ExpectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 400) {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	if config.ExpectedBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+		if err != nil {
+			return fmt.Errorf("reading body: %w", err)
+		}
+		if !strings.Contains(string(body), config.ExpectedBody) {
+			return fmt.Errorf("body doesn't contain %q", config.ExpectedBody)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package health
+
+import (
+	"httpServer/internal/balancer"
+	"httpServer/internal/logging"
+	"sync"
+	"time"
+)
+
+// OutlierConfig is the passive outlier detection of the upstreams of a route.
+type OutlierConfig struct {
+	// ConsecutiveFailures is how many failed requests in a row eject an upstream.
+	ConsecutiveFailures int
+	// BaseEjectionTime is how long the first ejection lasts, every further one
+	// lasts twice as long as the one before, up to MaxEjectionTime.
+	BaseEjectionTime time.Duration
+	MaxEjectionTime  time.Duration
+}
+
+type outlierState struct {
+	failures  int
+	ejections int
+}
+
+// OutlierDetector ejects upstreams whose requests keep failing. Unlike health
+// checks it only learns from the requests the route forwards.
+type OutlierDetector struct {
+	route  string
+	config OutlierConfig
+	logger logging.Logger
+
+	mutex  sync.Mutex
+	states map[*balancer.Upstream]*outlierState
+}
+
+func NewOutlierDetector(route string, config OutlierConfig, logger logging.Logger) *OutlierDetector {
+	return &OutlierDetector{
+		route:  route,
+		config: config,
+		logger: logger,
+		states: make(map[*balancer.Upstream]*outlierState),
+	}
+}
+
+// Report records whether a request to upstream failed, that is the upstream
+// answered with a 5xx status, couldn't be connected to or timed out.
+func (d *OutlierDetector) Report(upstream *balancer.Upstream, failed bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	state, ok := d.states[upstream]
+	if !ok {
+		state = &outlierState{}
+		d.states[upstream] = state
+	}
+
+	if !failed {
+		state.failures = 0
+		return
+	}
+
+	state.failures++
+	if state.failures < d.config.ConsecutiveFailures {
+		return
+	}
+
+	now := time.Now()
+	if now.Before(upstream.EjectedUntil()) {
+		// Requests sent before the ejection are still coming back
+		return
+	}
+	if now.Sub(upstream.EjectedUntil()) > d.config.MaxEjectionTime {
+		// The upstream behaved for long enough to start over
+		state.ejections = 0
+	}
+
+	ejection := d.config.BaseEjectionTime
+	for i := 0; i < state.ejections && ejection < d.config.MaxEjectionTime; i++ {
+		ejection *= 2
+	}
+	ejection = min(ejection, d.config.MaxEjectionTime)
+
+	state.ejections++
+	state.failures = 0
+	upstream.Eject(now.Add(ejection))
+
+	d.logger.Log(logging.LogLevelWarn, "Ejecting upstream %s of route %s for %v after %d failed requests in a row", upstream.URL, d.route, ejection, d.config.ConsecutiveFailures)
+}
@@ -2,104 +2,202 @@ package hpack
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 )
 
+var ErrIntegerOverflow = errors.New("integer representation overflows")
+
 type Decoder struct {
-	Table          IndexAddressSpace
-	LastIndexField int
+	Table   IndexAddressSpace
+	dynamic *dynamicTable
+
+	// MaxDynamicTableSize is the limit announced to the peer with SETTINGS_HEADER_TABLE_SIZE.
+	// Dynamic table size updates above it are a decoding error.
+	MaxDynamicTableSize int
 }
 
 func NewDecoder() *Decoder {
 	return &Decoder{
-		Table:          *initIndexAddressSpace(),
-		LastIndexField: -1,
+		Table:               *initIndexAddressSpace(),
+		dynamic:             newDynamicTable(DefaultMaxDynamicTableSize),
+		MaxDynamicTableSize: DefaultMaxDynamicTableSize,
+	}
+}
+
+// SetMaxDynamicTableSize changes the upper bound the encoder may use for the dynamic table.
+func (dec *Decoder) SetMaxDynamicTableSize(size int) {
+	dec.MaxDynamicTableSize = size
+	if dec.dynamic.maxSize > size {
+		dec.dynamic.setMaxSize(size)
+	}
+}
+
+// DynamicTable returns a copy of the dynamic table entries, newest first.
+func (dec *Decoder) DynamicTable() []HeaderField {
+	return dec.dynamic.snapshot()
+}
+
+// DynamicTableSize returns the current size of the dynamic table in octets.
+func (dec *Decoder) DynamicTableSize() int {
+	return dec.dynamic.size
+}
+
+func (dec *Decoder) lookup(index uint64) (HeaderField, error) {
+	if index == 0 {
+		return HeaderField{}, fmt.Errorf("indexes can't be 0")
+	}
+	if index <= STATIC_TABLE_SIZE {
+		return dec.Table[index-1], nil
+	}
+
+	dynamicIndex := index - STATIC_TABLE_SIZE - 1
+	if dynamicIndex >= uint64(len(dec.dynamic.entries)) {
+		return HeaderField{}, fmt.Errorf("index %d is out of the index address space", index)
+	}
+
+	return dec.dynamic.entries[dynamicIndex], nil
+}
+
+// decodeInteger reads an integer with an N-bit prefix (RFC 7541 Section 5.1).
+func decodeInteger(reader *bufio.Reader, first byte, prefix uint) (uint64, error) {
+	mask := byte(1<<prefix - 1)
+	value := uint64(first & mask)
+	if value < uint64(mask) {
+		return value, nil
+	}
+
+	var shift uint
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("truncated integer: %w", err)
+		}
+
+		if shift > 56 {
+			return 0, ErrIntegerOverflow
+		}
+		value += uint64(b&0x7F) << shift
+		shift += 7
+
+		if b&0x80 == 0 {
+			return value, nil
+		}
 	}
 }
 
 func decodeStringLiteral(reader *bufio.Reader) (string, error) {
-	length, err := reader.ReadByte()
+	first, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	length, err := decodeInteger(reader, first, 7)
 	if err != nil {
 		return "", err
 	}
 
-	if length&0x80 == 0x80 {
-		return "", errors.New("huffman decoding is not supported")
+	if length > math.MaxInt32 {
+		return "", ErrIntegerOverflow
 	}
-	length &= 0x7F
 
-	ret := make([]byte, length)
-	_, err = reader.Read(ret)
+	var buffer bytes.Buffer
+	_, err = io.CopyN(&buffer, reader, int64(length))
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("truncated string literal: %w", err)
+	}
+
+	if first&0x80 == 0x80 {
+		return HuffmanDecode(buffer.Bytes())
 	}
 
-	return string(ret), nil
+	return buffer.String(), nil
 }
 
-func literalHeaderFieldDecoding(reader *bufio.Reader) (*HeaderField, error) {
-	name, err := decodeStringLiteral(reader)
+func (dec *Decoder) literalHeaderFieldDecoding(reader *bufio.Reader, first byte, prefix uint, neverIndexed bool) (*HeaderField, error) {
+	index, err := decodeInteger(reader, first, prefix)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode name: %w", err)
+		return nil, err
+	}
+
+	var name string
+	if index == 0 {
+		name, err = decodeStringLiteral(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode name: %w", err)
+		}
+	} else {
+		indexed, err := dec.lookup(index)
+		if err != nil {
+			return nil, err
+		}
+		name = indexed.HeaderFieldName
 	}
+
 	value, err := decodeStringLiteral(reader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode value: %w", err)
 	}
 
-	return NewHeaderField(name, value, false), nil
+	return NewHeaderField(name, value, neverIndexed), nil
 }
 
+// Decode decodes one complete header block read from reader until EOF.
 func (dec *Decoder) Decode(reader *bufio.Reader) ([]HeaderField, error) {
-	headers := new([]HeaderField)
-	_, err := reader.Discard(1)
-	if err != nil {
-		return nil, err
-	}
+	headers := make([]HeaderField, 0)
 
 	for {
 		readByte, err := reader.ReadByte()
 		if errors.Is(err, io.EOF) {
 			break
 		} else if err != nil {
-			return *headers, fmt.Errorf("decoder error: %w", err)
+			return headers, fmt.Errorf("decoder error: %w", err)
 		}
 
-		if readByte&0x80 != 0 { // If in static table
-			index := readByte & 0x7F
-			if index == 0 {
-				return *headers, fmt.Errorf("static indexes can't be 0")
+		switch {
+		case readByte&0x80 == 0x80: // Indexed header field
+			index, err := decodeInteger(reader, readByte, 7)
+			if err != nil {
+				return headers, fmt.Errorf("failed to decode index: %w", err)
 			}
-
-			*headers = append(*headers, dec.Table[index-1])
-		} else if readByte&0xC0 == 0x40 { // If literal header field with incremental indexing
-			index := readByte & 0x20
-
-			if index == 0 {
-				header, err := literalHeaderFieldDecoding(reader)
-				if err != nil {
-					return *headers, fmt.Errorf("failed to decode header: %w", err)
-				}
-				*headers = append(*headers, *header)
+			header, err := dec.lookup(index)
+			if err != nil {
+				return headers, err
 			}
+			headers = append(headers, header)
 
-		} else if readByte&0xF0 == 0 { // If literal header field without indexing
-			index := readByte & 0xF
+		case readByte&0xC0 == 0x40: // Literal header field with incremental indexing
+			header, err := dec.literalHeaderFieldDecoding(reader, readByte, 6, false)
+			if err != nil {
+				return headers, fmt.Errorf("failed to decode header: %w", err)
+			}
+			dec.dynamic.add(*header)
+			headers = append(headers, *header)
 
-			if index == 0 {
-				header, err := literalHeaderFieldDecoding(reader)
-				if err != nil {
-					return *headers, fmt.Errorf("failed to decode header: %w", err)
-				}
-				*headers = append(*headers, *header)
+		case readByte&0xE0 == 0x20: // Dynamic table size update
+			if len(headers) != 0 {
+				return headers, fmt.Errorf("dynamic table size update after a header field")
 			}
+			size, err := decodeInteger(reader, readByte, 5)
+			if err != nil {
+				return headers, fmt.Errorf("failed to decode table size: %w", err)
+			}
+			if size > uint64(dec.MaxDynamicTableSize) {
+				return headers, fmt.Errorf("dynamic table size update %d exceeds limit %d", size, dec.MaxDynamicTableSize)
+			}
+			dec.dynamic.setMaxSize(int(size))
 
-		} else {
-			return *headers, fmt.Errorf("the decoder only supports static table indexing")
+		default: // Literal header field without indexing (0000) or never indexed (0001)
+			header, err := dec.literalHeaderFieldDecoding(reader, readByte, 4, readByte&0x10 == 0x10)
+			if err != nil {
+				return headers, fmt.Errorf("failed to decode header: %w", err)
+			}
+			headers = append(headers, *header)
 		}
 	}
 
-	return *headers, nil
+	return headers, nil
 }
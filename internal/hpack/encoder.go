@@ -3,25 +3,100 @@ package hpack
 const DefaultMaxDynamicTableSize = 4096
 
 type Encoder struct {
-	Table               *IndexAddressSpace
+	Table   IndexAddressSpace
+	dynamic *dynamicTable
+
 	MaxDynamicTableSize int
-	NextIndex           int
+	// UseHuffman Huffman encodes string literals unless that is longer than the raw octets.
+	UseHuffman bool
+
+	sizeUpdatePending bool
 }
 
 func NewEncoder(dynamicTableSize ...int) *Encoder {
-	staticTable := *initIndexAddressSpace()
-
 	maxTableSize := DefaultMaxDynamicTableSize
 	if len(dynamicTableSize) > 0 {
 		maxTableSize = dynamicTableSize[0]
 	}
 
-	dynamicTable := make(IndexAddressSpace, maxTableSize)
-	table := append(staticTable, dynamicTable...)
-
 	return &Encoder{
-		Table:               &table,
+		Table:               *initIndexAddressSpace(),
+		dynamic:             newDynamicTable(maxTableSize),
 		MaxDynamicTableSize: maxTableSize,
-		NextIndex:           STATIC_TABLE_SIZE + 1,
+		UseHuffman:          true,
+		sizeUpdatePending:   maxTableSize != DefaultMaxDynamicTableSize,
+	}
+}
+
+// SetMaxDynamicTableSize resizes the dynamic table and signals the change at
+// the start of the next header block.
+func (enc *Encoder) SetMaxDynamicTableSize(size int) {
+	enc.MaxDynamicTableSize = size
+	enc.dynamic.setMaxSize(size)
+	enc.sizeUpdatePending = true
+}
+
+// DynamicTable returns a copy of the dynamic table entries, newest first.
+func (enc *Encoder) DynamicTable() []HeaderField {
+	return enc.dynamic.snapshot()
+}
+
+func encodeInteger(dst []byte, first byte, prefix uint, value uint64) []byte {
+	mask := uint64(1<<prefix - 1)
+	if value < mask {
+		return append(dst, first|byte(value))
+	}
+
+	dst = append(dst, first|byte(mask))
+	value -= mask
+	for value >= 0x80 {
+		dst = append(dst, byte(value&0x7F)|0x80)
+		value >>= 7
+	}
+
+	return append(dst, byte(value))
+}
+
+func (enc *Encoder) encodeStringLiteral(dst []byte, s string) []byte {
+	if enc.UseHuffman && HuffmanEncodedLength(s) <= len(s) {
+		dst = encodeInteger(dst, 0x80, 7, uint64(HuffmanEncodedLength(s)))
+		return append(dst, HuffmanEncode(s)...)
+	}
+
+	dst = encodeInteger(dst, 0x00, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// Encode encodes headers as one header block. Fields marked NeverIndexed are
+// sent as never indexed literals, everything else is added to the dynamic table.
+func (enc *Encoder) Encode(headers []HeaderField) []byte {
+	var dst []byte
+
+	if enc.sizeUpdatePending {
+		dst = encodeInteger(dst, 0x20, 5, uint64(enc.MaxDynamicTableSize))
+		enc.sizeUpdatePending = false
 	}
+
+	for _, hf := range headers {
+		index, exact := enc.dynamic.search(hf.HeaderFieldName, hf.HeaderFieldValue)
+
+		if exact && !hf.NeverIndexed {
+			dst = encodeInteger(dst, 0x80, 7, uint64(index))
+			continue
+		}
+
+		if hf.NeverIndexed {
+			dst = encodeInteger(dst, 0x10, 4, uint64(index))
+		} else {
+			dst = encodeInteger(dst, 0x40, 6, uint64(index))
+			enc.dynamic.add(hf)
+		}
+
+		if index == 0 {
+			dst = enc.encodeStringLiteral(dst, hf.HeaderFieldName)
+		}
+		dst = enc.encodeStringLiteral(dst, hf.HeaderFieldValue)
+	}
+
+	return dst
 }
@@ -1 +1,95 @@
 package hpack
+
+import (
+	"errors"
+)
+
+// huffmanCode is a single entry of the canonical Huffman code from RFC 7541 Appendix B.
+type huffmanCode struct {
+	code   uint32
+	length uint8
+}
+
+const huffmanEOS = 256
+
+var ErrInvalidHuffmanCode = errors.New("invalid huffman code")
+
+// HuffmanEncodedLength returns the number of bytes s occupies once Huffman encoded.
+func HuffmanEncodedLength(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodes[s[i]].length)
+	}
+
+	return (bits + 7) / 8
+}
+
+// HuffmanEncode encodes s and pads the last byte with the most significant bits of EOS.
+func HuffmanEncode(s string) []byte {
+	encoded := make([]byte, 0, HuffmanEncodedLength(s))
+
+	var current uint64
+	var pending uint
+
+	for i := 0; i < len(s); i++ {
+		code := huffmanCodes[s[i]]
+		current = current<<code.length | uint64(code.code)
+		pending += uint(code.length)
+
+		for pending >= 8 {
+			pending -= 8
+			encoded = append(encoded, byte(current>>pending))
+		}
+	}
+
+	if pending > 0 {
+		current = current<<(8-pending) | (1<<(8-pending) - 1)
+		encoded = append(encoded, byte(current))
+	}
+
+	return encoded
+}
+
+// HuffmanDecode decodes a Huffman encoded string literal. Padding longer than
+// seven bits, padding that is not a prefix of EOS and an encoded EOS symbol
+// are treated as decoding errors (RFC 7541 Section 5.2).
+func HuffmanDecode(data []byte) (string, error) {
+	decoded := make([]byte, 0, len(data)*8/5)
+
+	node := 0
+	depth := 0
+	allOnes := true
+
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+
+			child := huffmanDecodeTree[node][bit]
+			if child == 0 {
+				return "", ErrInvalidHuffmanCode
+			}
+			depth++
+			allOnes = allOnes && bit == 1
+
+			if child > 0 {
+				node = int(child)
+				continue
+			}
+
+			symbol := -int(child) - 1
+			if symbol == huffmanEOS {
+				return "", ErrInvalidHuffmanCode
+			}
+			decoded = append(decoded, byte(symbol))
+			node = 0
+			depth = 0
+			allOnes = true
+		}
+	}
+
+	if depth > 7 || !allOnes {
+		return "", ErrInvalidHuffmanCode
+	}
+
+	return string(decoded), nil
+}
@@ -0,0 +1,126 @@
+// Code generated by tools/staticTable; DO NOT EDIT.
+
+package hpack
+
+const STATIC_TABLE_SIZE = 61
+
+var staticTable = [STATIC_TABLE_SIZE]HeaderField{
+	{HeaderFieldName: ":authority", HeaderFieldValue: ""},
+	{HeaderFieldName: ":method", HeaderFieldValue: "GET"},
+	{HeaderFieldName: ":method", HeaderFieldValue: "POST"},
+	{HeaderFieldName: ":path", HeaderFieldValue: "/"},
+	{HeaderFieldName: ":path", HeaderFieldValue: "/index.html"},
+	{HeaderFieldName: ":scheme", HeaderFieldValue: "http"},
+	{HeaderFieldName: ":scheme", HeaderFieldValue: "https"},
+	{HeaderFieldName: ":status", HeaderFieldValue: "200"},
+	{HeaderFieldName: ":status", HeaderFieldValue: "204"},
+	{HeaderFieldName: ":status", HeaderFieldValue: "206"},
+	{HeaderFieldName: ":status", HeaderFieldValue: "304"},
+	{HeaderFieldName: ":status", HeaderFieldValue: "400"},
+	{HeaderFieldName: ":status", HeaderFieldValue: "404"},
+	{HeaderFieldName: ":status", HeaderFieldValue: "500"},
+	{HeaderFieldName: "accept-charset", HeaderFieldValue: ""},
+	{HeaderFieldName: "accept-encoding", HeaderFieldValue: "gzip, deflate"},
+	{HeaderFieldName: "accept-language", HeaderFieldValue: ""},
+	{HeaderFieldName: "accept-ranges", HeaderFieldValue: ""},
+	{HeaderFieldName: "accept", HeaderFieldValue: ""},
+	{HeaderFieldName: "access-control-allow-origin", HeaderFieldValue: ""},
+	{HeaderFieldName: "age", HeaderFieldValue: ""},
+	{HeaderFieldName: "allow", HeaderFieldValue: ""},
+	{HeaderFieldName: "authorization", HeaderFieldValue: ""},
+	{HeaderFieldName: "cache-control", HeaderFieldValue: ""},
+	{HeaderFieldName: "content-disposition", HeaderFieldValue: ""},
+	{HeaderFieldName: "content-encoding", HeaderFieldValue: ""},
+	{HeaderFieldName: "content-language", HeaderFieldValue: ""},
+	{HeaderFieldName: "content-length", HeaderFieldValue: ""},
+	{HeaderFieldName: "content-location", HeaderFieldValue: ""},
+	{HeaderFieldName: "content-range", HeaderFieldValue: ""},
+	{HeaderFieldName: "content-type", HeaderFieldValue: ""},
+	{HeaderFieldName: "cookie", HeaderFieldValue: ""},
+	{HeaderFieldName: "date", HeaderFieldValue: ""},
+	{HeaderFieldName: "etag", HeaderFieldValue: ""},
+	{HeaderFieldName: "expect", HeaderFieldValue: ""},
+	{HeaderFieldName: "expires", HeaderFieldValue: ""},
+	{HeaderFieldName: "from", HeaderFieldValue: ""},
+	{HeaderFieldName: "host", HeaderFieldValue: ""},
+	{HeaderFieldName: "if-match", HeaderFieldValue: ""},
+	{HeaderFieldName: "if-modified-since", HeaderFieldValue: ""},
+	{HeaderFieldName: "if-none-match", HeaderFieldValue: ""},
+	{HeaderFieldName: "if-range", HeaderFieldValue: ""},
+	{HeaderFieldName: "if-unmodified-since", HeaderFieldValue: ""},
+	{HeaderFieldName: "last-modified", HeaderFieldValue: ""},
+	{HeaderFieldName: "link", HeaderFieldValue: ""},
+	{HeaderFieldName: "location", HeaderFieldValue: ""},
+	{HeaderFieldName: "max-forwards", HeaderFieldValue: ""},
+	{HeaderFieldName: "proxy-authenticate", HeaderFieldValue: ""},
+	{HeaderFieldName: "proxy-authorization", HeaderFieldValue: ""},
+	{HeaderFieldName: "range", HeaderFieldValue: ""},
+	{HeaderFieldName: "referer", HeaderFieldValue: ""},
+	{HeaderFieldName: "refresh", HeaderFieldValue: ""},
+	{HeaderFieldName: "retry-after", HeaderFieldValue: ""},
+	{HeaderFieldName: "server", HeaderFieldValue: ""},
+	{HeaderFieldName: "set-cookie", HeaderFieldValue: ""},
+	{HeaderFieldName: "strict-transport-security", HeaderFieldValue: ""},
+	{HeaderFieldName: "transfer-encoding", HeaderFieldValue: ""},
+	{HeaderFieldName: "user-agent", HeaderFieldValue: ""},
+	{HeaderFieldName: "vary", HeaderFieldValue: ""},
+	{HeaderFieldName: "via", HeaderFieldValue: ""},
+	{HeaderFieldName: "www-authenticate", HeaderFieldValue: ""},
+}
+
+// staticNameIndex maps a header name to the first static table index carrying it.
+// Entries with the same name are adjacent in the static table.
+var staticNameIndex = map[string]int{
+	":authority":                  1,
+	":method":                     2,
+	":path":                       4,
+	":scheme":                     6,
+	":status":                     8,
+	"accept-charset":              15,
+	"accept-encoding":             16,
+	"accept-language":             17,
+	"accept-ranges":               18,
+	"accept":                      19,
+	"access-control-allow-origin": 20,
+	"age":                         21,
+	"allow":                       22,
+	"authorization":               23,
+	"cache-control":               24,
+	"content-disposition":         25,
+	"content-encoding":            26,
+	"content-language":            27,
+	"content-length":              28,
+	"content-location":            29,
+	"content-range":               30,
+	"content-type":                31,
+	"cookie":                      32,
+	"date":                        33,
+	"etag":                        34,
+	"expect":                      35,
+	"expires":                     36,
+	"from":                        37,
+	"host":                        38,
+	"if-match":                    39,
+	"if-modified-since":           40,
+	"if-none-match":               41,
+	"if-range":                    42,
+	"if-unmodified-since":         43,
+	"last-modified":               44,
+	"link":                        45,
+	"location":                    46,
+	"max-forwards":                47,
+	"proxy-authenticate":          48,
+	"proxy-authorization":         49,
+	"range":                       50,
+	"referer":                     51,
+	"refresh":                     52,
+	"retry-after":                 53,
+	"server":                      54,
+	"set-cookie":                  55,
+	"strict-transport-security":   56,
+	"transfer-encoding":           57,
+	"user-agent":                  58,
+	"vary":                        59,
+	"via":                         60,
+	"www-authenticate":            61,
+}
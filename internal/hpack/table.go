@@ -0,0 +1,75 @@
+package hpack
+
+// dynamicTable is the FIFO header table of RFC 7541 Section 2.3.2. The newest
+// entry is stored first, so entries[0] is addressed by STATIC_TABLE_SIZE + 1.
+type dynamicTable struct {
+	entries []HeaderField
+	size    int
+	maxSize int
+}
+
+func newDynamicTable(maxSize int) *dynamicTable {
+	return &dynamicTable{maxSize: maxSize}
+}
+
+func (t *dynamicTable) add(hf HeaderField) {
+	hf.NeverIndexed = false
+
+	// An entry larger than the table empties it and is not inserted (RFC 7541 Section 4.4)
+	if hf.Size() > t.maxSize {
+		t.entries = t.entries[:0]
+		t.size = 0
+		return
+	}
+
+	t.evict(t.maxSize - hf.Size())
+	t.entries = append([]HeaderField{hf}, t.entries...)
+	t.size += hf.Size()
+}
+
+func (t *dynamicTable) setMaxSize(maxSize int) {
+	t.maxSize = maxSize
+	t.evict(maxSize)
+}
+
+// evict drops the oldest entries until the table size is at most limit.
+func (t *dynamicTable) evict(limit int) {
+	for t.size > limit && len(t.entries) > 0 {
+		last := len(t.entries) - 1
+		t.size -= t.entries[last].Size()
+		t.entries = t.entries[:last]
+	}
+}
+
+// search returns the index address space index of an exact match, or of a
+// name-only match if no exact match exists. The static table is preferred.
+func (t *dynamicTable) search(name string, value string) (index int, exact bool) {
+	if first, ok := staticNameIndex[name]; ok {
+		index = first
+		for i := first - 1; i < STATIC_TABLE_SIZE && staticTable[i].HeaderFieldName == name; i++ {
+			if staticTable[i].HeaderFieldValue == value {
+				return i + 1, true
+			}
+		}
+	}
+
+	for i, hf := range t.entries {
+		if hf.HeaderFieldName == name {
+			if hf.HeaderFieldValue == value {
+				return STATIC_TABLE_SIZE + i + 1, true
+			}
+			if index == 0 {
+				index = STATIC_TABLE_SIZE + i + 1
+			}
+		}
+	}
+
+	return index, false
+}
+
+func (t *dynamicTable) snapshot() []HeaderField {
+	entries := make([]HeaderField, len(t.entries))
+	copy(entries, t.entries)
+
+	return entries
+}
@@ -15,7 +15,7 @@ func ParseFrame(reader *bufio.Reader) (*structs.Frame, error) {
 	var buffer bytes.Buffer
 	_, err := io.CopyN(&buffer, reader, 9)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read frame data: %v", err)
+		return nil, fmt.Errorf("cannot read frame data: %w", err)
 	}
 
 	var length []byte
@@ -32,7 +32,7 @@ func ParseFrame(reader *bufio.Reader) (*structs.Frame, error) {
 
 	_, err = io.CopyN(&buffer, reader, int64(newFrame.Length))
 	if err != nil {
-		return nil, fmt.Errorf("cannot read frame data: %v", err)
+		return nil, fmt.Errorf("cannot read frame data: %w", err)
 	}
 	newFrame.Payload = buffer.Bytes()
 
@@ -4,7 +4,10 @@ import (
 	"crypto/tls"
 	"github.com/go-chi/chi/v5"
 	hpack "github.com/tatsuhiro-t/go-http2-hpack"
+	"httpServer/internal/logging"
+	"httpServer/internal/response"
 	"net"
+	"net/http"
 	"sync"
 )
 
@@ -30,18 +33,34 @@ const (
 	ACK              = 0x01
 )
 
+// StreamLimits bounds the size of a request on a stream, zero disables a limit.
+type StreamLimits struct {
+	MaxHeaderListSize int
+	MaxURILength      int
+	MaxBodyBytes      int64
+	// CheckRoute returns the status the route of a request rejects it with, 0 if
+	// it is served. It decides an expectation before the body is sent.
+	CheckRoute func(r *http.Request) int
+}
+
 type ParsingEssential struct {
 	Mutex    *sync.Mutex
 	Dec      *hpack.Decoder
 	Channels map[uint32]*Communication
 	Router   chi.Router
 	Conn     *tls.Conn
+	Limits   StreamLimits
 }
 
 type ResponseEssential struct {
 	Connection net.Conn
 	Enc        *hpack.Encoder // TODO: Pull max table size from settings??
 	FrameChan  chan *Frame
+	Options    response.Options
+	Logger     logging.Logger
+	// Sent is closed once the frame writer stopped, after FrameChan was closed or a write failed.
+	// Frames are only sent while it is open.
+	Sent chan struct{}
 }
 
 type Frame struct {
@@ -54,6 +73,8 @@ type Frame struct {
 
 type Communication struct {
 	Frames chan Frame
+	// Received is called once the whole request of the stream was read, it may be nil.
+	Received func()
 
 	Mutex *sync.Mutex
 	Dec   *hpack.Decoder
@@ -67,20 +88,24 @@ func NewCommunication(dec *hpack.Decoder, mut *sync.Mutex) *Communication {
 	}
 }
 
-func NewParsingEssential(dec *hpack.Decoder, mut *sync.Mutex, r chi.Router, conn *tls.Conn) *ParsingEssential {
+func NewParsingEssential(dec *hpack.Decoder, mut *sync.Mutex, r chi.Router, conn *tls.Conn, limits StreamLimits) *ParsingEssential {
 	return &ParsingEssential{
 		Dec:      dec,
 		Channels: make(map[uint32]*Communication),
 		Mutex:    mut,
 		Router:   r,
 		Conn:     conn,
+		Limits:   limits,
 	}
 }
 
-func NewResponseEssential(conn net.Conn, enc *hpack.Encoder) *ResponseEssential {
+func NewResponseEssential(conn net.Conn, enc *hpack.Encoder, options response.Options, logger logging.Logger) *ResponseEssential {
 	return &ResponseEssential{
 		Connection: conn,
 		Enc:        enc,
 		FrameChan:  make(chan *Frame),
+		Options:    options,
+		Logger:     logger,
+		Sent:       make(chan struct{}),
 	}
 }
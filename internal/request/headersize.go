@@ -0,0 +1,33 @@
+package request
+
+import (
+	"context"
+	"net/http"
+)
+
+// HeaderSize is the header section of a request as the size limits count it:
+// the field lines as they were received, not the values they were split into,
+// and their bytes as "name: value\r\n".
+type HeaderSize struct {
+	Fields int
+	Bytes  int
+}
+
+type headerSizeKey struct{}
+
+// AddField counts a field line of name and value.
+func (s *HeaderSize) AddField(name, value string) {
+	s.Fields++
+	s.Bytes += len(name) + len(": ") + len(value) + len("\r\n")
+}
+
+// WithHeaderSize returns a copy of r that carries the size of its header section.
+func WithHeaderSize(r *http.Request, size HeaderSize) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), headerSizeKey{}, size))
+}
+
+// HeaderSizeOf returns the size the parser recorded for the header section of r.
+func HeaderSizeOf(r *http.Request) (HeaderSize, bool) {
+	size, ok := r.Context().Value(headerSizeKey{}).(HeaderSize)
+	return size, ok
+}
@@ -0,0 +1,211 @@
+package http1_1
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var ErrBodyReadAfterClose = errors.New("http: invalid Read on closed Body")
+
+// body streams a request body straight from the connection. Whatever the
+// handler leaves unread has to be drained with DrainBody before the next
+// request on the connection can be parsed.
+type body struct {
+	src    io.Reader
+	sawEOF bool
+	closed bool
+}
+
+func newBody(src io.Reader) *body {
+	return &body{src: src}
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyReadAfterClose
+	}
+	if b.sawEOF {
+		return 0, io.EOF
+	}
+
+	n, err := b.src.Read(p)
+	if errors.Is(err, io.EOF) {
+		b.sawEOF = true
+	}
+
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *body) drain() error {
+	if b.sawEOF {
+		return nil
+	}
+
+	_, err := io.Copy(io.Discard, b.src)
+	b.sawEOF = true
+	return err
+}
+
+// DrainBody discards the unread remainder of a request body returned by Parser,
+// so the connection is positioned at the start of the next pipelined request.
+func DrainBody(req *http.Request) error {
+	if b, ok := req.Body.(*body); ok {
+		return b.drain()
+	}
+
+	return nil
+}
+
+// contentLengthReader reads exactly length bytes and reports a truncated body
+// as io.ErrUnexpectedEOF instead of a clean EOF.
+type contentLengthReader struct {
+	reader    *bufio.Reader
+	remaining int64
+}
+
+func (r *contentLengthReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && r.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == nil && r.remaining == 0 {
+		return n, io.EOF
+	}
+
+	return n, err
+}
+
+// chunkedReader decodes a chunked transfer coding incrementally. Once the
+// last chunk has been read the trailer section is parsed into req.Trailer.
+type chunkedReader struct {
+	reader    *bufio.Reader
+	req       *http.Request
+	options   Options
+	remaining int64
+	done      bool
+	err       error
+}
+
+func newChunkedReader(reader *bufio.Reader, req *http.Request, options Options) *chunkedReader {
+	return &chunkedReader{reader: reader, req: req, options: options}
+}
+
+func (cr *chunkedReader) readChunkSize() (int64, error) {
+	chunkSizeStr, err := readLine(cr.reader, cr.options, maxChunkLineLength)
+	if err != nil {
+		return 0, fmt.Errorf("%w: can't read chunk size: %w", ErrMalformedChunk, err)
+	}
+
+	// Disregard any chunk extensions
+	chunkSizeCut, _, found := strings.Cut(chunkSizeStr, ";")
+	if found {
+		chunkSizeStr = chunkSizeCut
+	}
+
+	if !cr.options.Lenient && !isHexDigits(chunkSizeStr) {
+		return 0, fmt.Errorf("%w: %w", ErrMalformedChunk, strictViolation("invalid chunk size %q", chunkSizeStr))
+	}
+
+	chunkSize, err := strconv.ParseInt(strings.TrimSpace(chunkSizeStr), 16, 64)
+	if err != nil || chunkSize < 0 {
+		return 0, fmt.Errorf("%w: can't read chunk size: %q", ErrMalformedChunk, chunkSizeStr)
+	}
+
+	return chunkSize, nil
+}
+
+func (cr *chunkedReader) readChunkEnd() error {
+	end, err := readLine(cr.reader, cr.options, maxChunkLineLength)
+	if err != nil {
+		return fmt.Errorf("%w: can't read chunk end: %w", ErrMalformedChunk, err)
+	}
+	if end != "" {
+		return fmt.Errorf("%w: chunk data exceeds its size", ErrMalformedChunk)
+	}
+
+	return nil
+}
+
+func (cr *chunkedReader) readTrailer() error {
+	trailer, _, err := readHeader(cr.reader, cr.options)
+	if err != nil {
+		return fmt.Errorf("%w: can't read trailer: %w", ErrMalformedChunk, err)
+	}
+
+	if cr.req.Trailer == nil {
+		cr.req.Trailer = make(http.Header)
+	}
+	for key, values := range trailer {
+		cr.req.Trailer[key] = values
+	}
+
+	return nil
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.done {
+		return 0, io.EOF
+	}
+
+	if cr.remaining == 0 {
+		chunkSize, err := cr.readChunkSize()
+		if err != nil {
+			cr.err = err
+			return 0, err
+		}
+
+		if chunkSize == 0 {
+			if err := cr.readTrailer(); err != nil {
+				cr.err = err
+				return 0, err
+			}
+			cr.done = true
+			return 0, io.EOF
+		}
+		cr.remaining = chunkSize
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+
+	n, err := cr.reader.Read(p)
+	cr.remaining -= int64(n)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		cr.err = err
+		return n, err
+	}
+
+	if cr.remaining == 0 {
+		if err := cr.readChunkEnd(); err != nil {
+			cr.err = err
+			return n, err
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,54 @@
+package http1_1
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedVersion        = errors.New("unsupported HTTP version")
+	ErrUnsupportedTransferCoding = errors.New("unsupported transfer coding")
+	// ErrMalformedChunk is returned while reading a chunked body whose framing is broken.
+	ErrMalformedChunk = errors.New("malformed chunked body")
+)
+
+// ParseError is returned by Parser for requests that have to be answered with
+// an error status before the connection is closed.
+type ParseError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// newParseError attaches the status a request failing with err is answered with.
+// Errors reading from the connection are returned as they are, there is no
+// client left to answer.
+func newParseError(err error) error {
+	var netErr net.Error
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr) {
+		return err
+	}
+
+	statusCode := http.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrURITooLong):
+		statusCode = http.StatusRequestURITooLong
+	case errors.Is(err, ErrHeaderTooLarge):
+		statusCode = http.StatusRequestHeaderFieldsTooLarge
+	case errors.Is(err, ErrUnsupportedTransferCoding):
+		statusCode = http.StatusNotImplemented
+	case errors.Is(err, ErrUnsupportedVersion):
+		statusCode = http.StatusHTTPVersionNotSupported
+	}
+
+	return &ParseError{StatusCode: statusCode, Err: err}
+}
@@ -0,0 +1,24 @@
+package http1_1
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ExpectsContinue reports whether the client waits for a 100 Continue before
+// sending the body. HTTP/1.0 clients don't understand interim responses, so
+// their expectation is ignored (RFC 9110 Section 10.1.1).
+func ExpectsContinue(req *http.Request) bool {
+	return req.ProtoAtLeast(1, 1) && strings.EqualFold(strings.TrimSpace(req.Header.Get("Expect")), "100-continue")
+}
+
+// HasUnsupportedExpectation reports an Expect header other than 100-continue,
+// which has to be answered with 417 Expectation Failed.
+func HasUnsupportedExpectation(req *http.Request) bool {
+	expect, ok := req.Header["Expect"]
+	if !ok || !req.ProtoAtLeast(1, 1) {
+		return false
+	}
+
+	return len(expect) != 1 || !strings.EqualFold(strings.TrimSpace(expect[0]), "100-continue")
+}
@@ -2,10 +2,9 @@ package http1_1
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"fmt"
-	"io"
+	"httpServer/internal/request"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -14,10 +13,28 @@ import (
 
 var ChunkEncodingError = errors.New("chunked encoding was not at the end of the transfer encodings")
 
-func parseStartLine(reader *bufio.Reader, req *http.Request) error {
-	startLine, err := reader.ReadString('\n')
+// knownTransferCodings are the codings registered for HTTP/1.1 (RFC 9112 Section 7).
+var knownTransferCodings = map[string]bool{
+	"chunked":    true,
+	"compress":   true,
+	"deflate":    true,
+	"gzip":       true,
+	"x-compress": true,
+	"x-gzip":     true,
+}
+
+func parseStartLine(reader *bufio.Reader, req *http.Request, options Options) error {
+	startLineLimit := 0
+	if options.MaxURILength > 0 {
+		startLineLimit = options.MaxURILength + startLineOverhead
+	}
+
+	startLine, err := readLine(reader, options, startLineLimit)
+	if errors.Is(err, errLineTooLong) {
+		return ErrURITooLong
+	}
 	if err != nil {
-		return fmt.Errorf("can't scan start-line: %v", err)
+		return fmt.Errorf("can't scan start-line: %w", err)
 	}
 
 	splitStartLine := strings.Split(startLine, " ")
@@ -26,8 +43,14 @@ func parseStartLine(reader *bufio.Reader, req *http.Request) error {
 	}
 
 	req.Method = splitStartLine[0]
+	if !options.Lenient && !isToken(req.Method) {
+		return strictViolation("invalid method %q", req.Method)
+	}
 
 	uri := splitStartLine[1]
+	if options.MaxURILength > 0 && len(uri) > options.MaxURILength {
+		return ErrURITooLong
+	}
 	req.RequestURI = uri
 
 	u, err := url.ParseRequestURI(uri)
@@ -36,12 +59,12 @@ func parseStartLine(reader *bufio.Reader, req *http.Request) error {
 	}
 	req.URL = u
 
-	version := strings.Trim(splitStartLine[2], "\r\n")
+	version := splitStartLine[2]
 
-	if version[:5] != "HTTP/" {
+	if len(version) != len("HTTP/x.x") {
 		return fmt.Errorf("proto format error: %v", version)
 	}
-	if len(version) != len("HTTP/x.x") {
+	if version[:5] != "HTTP/" {
 		return fmt.Errorf("proto format error: %v", version)
 	}
 
@@ -61,28 +84,52 @@ func parseStartLine(reader *bufio.Reader, req *http.Request) error {
 	req.ProtoMinor = protoMinor
 	req.ProtoMajor = protoMajor
 
+	// Later HTTP/1 minor versions are answered as HTTP/1.1
+	if protoMajor != 1 {
+		return fmt.Errorf("%w: %v", ErrUnsupportedVersion, version)
+	}
+
 	return nil
 }
 
-func readHeader(reader *bufio.Reader) (http.Header, error) {
+func readHeader(reader *bufio.Reader, options Options) (http.Header, request.HeaderSize, error) {
 	header := make(http.Header)
+	budget := newHeaderBudget(options)
 
 	for {
-		row, err := reader.ReadString('\n')
+		row, err := readLine(reader, options, budget.lineLimit())
+		if errors.Is(err, errLineTooLong) {
+			return nil, request.HeaderSize{}, ErrHeaderTooLarge
+		}
 		if err != nil {
-			return nil, fmt.Errorf("can't scan header: %v", err)
+			return nil, request.HeaderSize{}, fmt.Errorf("can't scan header: %w", err)
 		}
-		row = strings.Trim(row, "\r\n")
 
 		if row == "" {
 			break
 		}
 
+		if err := budget.consume(row); err != nil {
+			return nil, request.HeaderSize{}, err
+		}
+
+		if !options.Lenient && (row[0] == ' ' || row[0] == '\t') {
+			return nil, request.HeaderSize{}, strictViolation("obsolete line folding: %q", row)
+		}
+
 		// Get key value pairs
 		key, value, found := strings.Cut(row, ":")
 		if !found {
-			fmt.Println(header)
-			return nil, fmt.Errorf("header seperator not found: %q", row)
+			return nil, request.HeaderSize{}, fmt.Errorf("header seperator not found: %q", row)
+		}
+
+		if !options.Lenient {
+			if !isToken(key) {
+				return nil, request.HeaderSize{}, strictViolation("invalid header name %q", key)
+			}
+			if hasControlChars(value) {
+				return nil, request.HeaderSize{}, strictViolation("control character in value of header %q", key)
+			}
 		}
 
 		// Split up after each comma for header insertion
@@ -93,135 +140,134 @@ func readHeader(reader *bufio.Reader) (http.Header, error) {
 		}
 	}
 
-	return header, nil
+	return header, request.HeaderSize{Fields: budget.count, Bytes: budget.bytes}, nil
 }
 
-func parseHeader(reader *bufio.Reader, req *http.Request) error {
-	header, err := readHeader(reader)
+func parseHeader(reader *bufio.Reader, req *http.Request, options Options) (request.HeaderSize, error) {
+	header, size, err := readHeader(reader, options)
 	if err != nil {
-		fmt.Println(req)
-		return err
+		return size, err
 	}
 
 	req.Header = header
-	return nil
+	return size, nil
 }
 
-func parseChunkedData(reader *bufio.Reader, req *http.Request) (string, error) {
-	var bodyContentBuffer string
-
-	// Respect \n
-	for {
-		chunkSizeStr, err := reader.ReadString('\n')
-		if err != nil {
-			return bodyContentBuffer, fmt.Errorf("can't read chunk size: %v", err)
-		}
-
-		chunkSizeStr = strings.Trim(chunkSizeStr, "\r\n")
-
-		// Disregard any chunk extensions
-		chunkSizeCut, _, found := strings.Cut(chunkSizeStr, ";")
-		if found {
-			chunkSizeStr = chunkSizeCut
-		}
-
-		chunkSize, err := strconv.ParseInt(chunkSizeStr, 16, 64)
-		if err != nil {
-			return bodyContentBuffer, fmt.Errorf("can't read chunk size: %v", err)
-		}
-
-		if chunkSize == 0 {
-			break
-		}
-
-		buffer, err := io.ReadAll(io.LimitReader(reader, chunkSize+2))
-		if err != nil {
-			return bodyContentBuffer, fmt.Errorf("can't read chunk content: %v", err)
-		}
-		buffer = bytes.Trim(buffer, "\r\n")
-
-		bodyContentBuffer += string(buffer)
-	}
-
-	// checking if a trailer section is present
-	trailer, err := readHeader(reader)
-	if err != nil {
-		return bodyContentBuffer, err
+func parseBody(reader *bufio.Reader, req *http.Request, options Options) error {
+	if !options.Lenient && len(req.Header.Values("Host")) > 1 {
+		return strictViolation("multiple Host headers")
 	}
-	req.Trailer = trailer
-	return bodyContentBuffer, nil
-}
-
-func parseBody(reader *bufio.Reader, req *http.Request) error {
 	req.Host = req.Header.Get("Host")
 	req.Header.Del("Host")
 
 	req.TransferEncoding = req.Header.Values("Transfer-Encoding")
 
+	contentLengths := req.Header.Values("Content-Length")
 	contentLengthString := req.Header.Get("Content-Length")
 	contentLength, err := strconv.ParseInt(contentLengthString, 10, 64)
 
+	if !options.Lenient {
+		if len(req.TransferEncoding) > 0 && len(contentLengths) > 0 {
+			return strictViolation("both Content-Length and Transfer-Encoding are present")
+		}
+		if len(contentLengths) > 1 {
+			return strictViolation("multiple Content-Length values: %q", contentLengths)
+		}
+		if len(contentLengths) == 1 && !isDigits(contentLengthString) {
+			return strictViolation("invalid Content-Length %q", contentLengthString)
+		}
+	}
+
 	// Checking if chunked transfer-encoded
 	// Chunked transfer encoding overwrites the content-length header
-	chunked := false
+	chunked := 0
 	for _, encoding := range req.TransferEncoding {
-		if strings.ToLower(encoding) == "chunked" {
-			chunked = true
+		if !knownTransferCodings[strings.ToLower(encoding)] {
+			return fmt.Errorf("%w: %q", ErrUnsupportedTransferCoding, encoding)
+		}
+		if strings.EqualFold(encoding, "chunked") {
+			chunked++
 		}
 	}
 
-	if chunked && req.TransferEncoding[len(req.TransferEncoding)-1] != "chunked" {
+	if chunked > 0 && !strings.EqualFold(req.TransferEncoding[len(req.TransferEncoding)-1], "chunked") {
 		return ChunkEncodingError
 	}
+	if !options.Lenient && chunked > 1 {
+		return strictViolation("chunked applied more than once: %q", req.TransferEncoding)
+	}
+	if !options.Lenient && len(req.TransferEncoding) > 0 && chunked == 0 {
+		return strictViolation("transfer coding without chunked as final coding: %q", req.TransferEncoding)
+	}
 
-	if chunked {
+	if chunked > 0 {
 		if contentLength != 0 {
 			delete(req.Header, "Content-Length")
-			req.ContentLength = 0
 		}
 
-		bodyContentBuffer, err := parseChunkedData(reader, req)
-		if err != nil {
-			return err
+		// Announced trailer fields are known up front, their values are filled in at EOF
+		for _, name := range req.Header.Values("Trailer") {
+			if req.Trailer == nil {
+				req.Trailer = make(http.Header)
+			}
+			req.Trailer[http.CanonicalHeaderKey(name)] = nil
 		}
-		req.Body = io.NopCloser(strings.NewReader(bodyContentBuffer))
+
+		req.ContentLength = -1
+		req.Body = newBody(newChunkedReader(reader, req, options))
 	} else if contentLengthString != "" {
-		if err != nil {
-			return fmt.Errorf("error parsing Content-Length: %v", err)
+		if err != nil || contentLength < 0 {
+			return fmt.Errorf("error parsing Content-Length: %q", contentLengthString)
 		}
 		req.ContentLength = contentLength
 
-		bodyContentBuffer, err := io.ReadAll(io.LimitReader(reader, contentLength))
-		if err != nil {
-			return fmt.Errorf("error reading chunked body content: %v", err)
-		}
-
-		req.Body = io.NopCloser(strings.NewReader(string(bodyContentBuffer)))
+		req.Body = newBody(&contentLengthReader{reader: reader, remaining: contentLength})
+	} else {
+		req.Body = http.NoBody
 	}
 
 	return nil
 }
 
-func Parser(reader *bufio.Reader) (*http.Request, error, bool) {
+// Parser reads the start-line and header section of the next request on the
+// connection, the body is streamed on demand. Requests that can't be accepted
+// fail with a *ParseError carrying the status to answer with.
+func Parser(reader *bufio.Reader, options Options) (*http.Request, error, bool) {
 	r := http.Request{}
 
-	err := parseStartLine(reader, &r)
+	err := parseStartLine(reader, &r, options)
 	if err != nil {
-		return nil, err, false
+		return nil, newParseError(err), false
 	}
 
-	err = parseHeader(reader, &r)
+	size, err := parseHeader(reader, &r, options)
 	if err != nil {
-		return nil, err, false
+		return nil, newParseError(err), false
 	}
 
-	err = parseBody(reader, &r)
+	err = parseBody(reader, &r, options)
 	if err != nil {
-		return nil, err, false
+		return nil, newParseError(err), false
 	}
 
-	if r.Header.Get("Connection") == "keep-alive" || (r.Header.Get("Connection") == "" && r.ProtoMinor == 1) {
-		return &r, nil, true
+	// Limits of routes are checked later against the field lines as received
+	req := request.WithHeaderSize(&r, size)
+
+	// HTTP/1.1 connections persist unless closed, HTTP/1.0 ones only on request
+	if req.ProtoAtLeast(1, 1) {
+		return req, nil, !hasConnectionToken(req.Header, "close")
 	}
-	return &r, nil, false
+	return req, nil, hasConnectionToken(req.Header, "keep-alive")
+}
+
+func hasConnectionToken(header http.Header, token string) bool {
+	for _, value := range header.Values("Connection") {
+		for _, element := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(element), token) {
+				return true
+			}
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,54 @@
+package http1_1
+
+import (
+	"errors"
+)
+
+var (
+	ErrURITooLong     = errors.New("request URI too long")
+	ErrHeaderTooLarge = errors.New("request header fields too large")
+
+	errLineTooLong = errors.New("line too long")
+)
+
+// startLineOverhead is the room left for the method, the version and the two
+// spaces of a start-line on top of the URI length limit.
+const startLineOverhead = 64
+
+// maxChunkLineLength bounds chunk size lines including chunk extensions.
+const maxChunkLineLength = 4096
+
+// headerBudget tracks the header fields read so far against MaxHeaderCount and MaxHeaderBytes.
+type headerBudget struct {
+	options Options
+	count   int
+	bytes   int
+}
+
+func newHeaderBudget(options Options) *headerBudget {
+	return &headerBudget{options: options}
+}
+
+// lineLimit returns the longest line that can still be read, zero if unlimited.
+// The two extra bytes leave room for the CRLF ending the header section.
+func (b *headerBudget) lineLimit() int {
+	if b.options.MaxHeaderBytes == 0 {
+		return 0
+	}
+
+	return b.options.MaxHeaderBytes - b.bytes + 2
+}
+
+func (b *headerBudget) consume(row string) error {
+	b.count++
+	b.bytes += len(row) + len("\r\n")
+
+	if b.options.MaxHeaderCount > 0 && b.count > b.options.MaxHeaderCount {
+		return ErrHeaderTooLarge
+	}
+	if b.options.MaxHeaderBytes > 0 && b.bytes > b.options.MaxHeaderBytes {
+		return ErrHeaderTooLarge
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package http1_1
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrStrictViolation wraps every input the strict parser refuses because it
+// can be interpreted differently by another HTTP implementation (request smuggling).
+var ErrStrictViolation = errors.New("request rejected by strict parsing")
+
+// Options controls how forgiving the parser is. The zero value is strict and unlimited.
+type Options struct {
+	// Lenient accepts bare LF line endings, obs-fold lines, invalid tokens and
+	// ambiguous message framing, as the parser did before strict mode existed.
+	Lenient bool
+
+	// Size limits, zero disables the respective limit
+	MaxURILength   int
+	MaxHeaderCount int
+	MaxHeaderBytes int
+}
+
+func strictViolation(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrStrictViolation, fmt.Sprintf(format, args...))
+}
+
+// readLine reads one line of at most limit bytes and strips its line ending.
+// A limit of zero reads lines of any length. Strict mode requires CRLF.
+func readLine(reader *bufio.Reader, options Options, limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if limit > 0 && len(line) > limit {
+			return "", errLineTooLong
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+
+	if !options.Lenient && !bytes.HasSuffix(line, []byte("\r\n")) {
+		return "", strictViolation("bare LF line ending in %q", line)
+	}
+
+	return strings.Trim(string(line), "\r\n"), nil
+}
+
+// isTokenChar reports whether c is a tchar (RFC 9110 Section 5.6.2).
+func isTokenChar(c byte) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasControlChars reports whether a field value contains CTLs other than HTAB.
+func hasControlChars(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < ' ' && s[i] != '\t') || s[i] == 0x7F {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isHexDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+
+	return true
+}
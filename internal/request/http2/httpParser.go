@@ -7,12 +7,14 @@ import (
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/tatsuhiro-t/go-http2-hpack"
-	"httpServer/internal/http2/frame"
 	"httpServer/internal/http2/structs"
+	"httpServer/internal/logging"
+	"httpServer/internal/request"
 	"httpServer/internal/response/http2"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -29,6 +31,9 @@ func parseHeader(key string, value string, r *http.Request) error {
 		r.URL = u
 	} else if key == ":authority" {
 		r.Host = value
+	} else if key == "host" && r.Host == "" {
+		// Clients may send Host instead of :authority
+		r.Host = value
 	}
 
 	if key[0] == ':' {
@@ -85,14 +90,16 @@ func parseHeaders(frame *structs.Frame, r *http.Request, dec *hpack.Decoder, mut
 	bufferBytes := buffer.Bytes()
 	bufferBytes = bufferBytes[:len(bufferBytes)-int(paddingLength)]
 
+	// Limits of routes are checked later against the fields as received
+	size, _ := request.HeaderSizeOf(r)
+
 	// TODO: decode all header payloads as one
 	for {
 		mutex.Lock()
 		headerContent, nPos, err := dec.Decode(bufferBytes[pos:], true)
 		mutex.Unlock()
 		if err != nil {
-			fmt.Println(fmt.Sprintf("cannot read header content: %v", err))
-			break
+			return fmt.Errorf("cannot decode header block: %w", err)
 		}
 
 		if headerContent == nil {
@@ -105,7 +112,11 @@ func parseHeaders(frame *structs.Frame, r *http.Request, dec *hpack.Decoder, mut
 		if err != nil {
 			return err
 		}
+		if headerContent.Name[0] != ':' {
+			size.AddField(headerContent.Name, headerContent.Value)
+		}
 	}
+	*r = *request.WithHeaderSize(r, size)
 
 	r.Proto = "HTTP/2.0"
 	r.ProtoMajor = 2
@@ -172,13 +183,90 @@ func parseDataFrame(frame structs.Frame, bodyContent *string) (bool, error) {
 	return true, nil
 }
 
-func HandleMultiplexedFrameParsing(comm *structs.Communication, router chi.Router, conn *tls.Conn, respEssential structs.ResponseEssential) {
-	r := new(http.Request)
+// headerListSize returns the size of the decoded header list as defined for
+// SETTINGS_MAX_HEADER_LIST_SIZE (RFC 9113 Section 6.5.2).
+func headerListSize(r *http.Request) int {
+	const overhead = 32
+
+	size := len(":method") + len(r.Method) + len(":path") + len(r.RequestURI) + len(":authority") + len(r.Host) + 3*overhead
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += len(name) + len(value) + overhead
+		}
+	}
+
+	return size
+}
+
+// exceedsLimits returns the status code a stream exceeding limits is rejected with, 0 if it fits.
+func exceedsLimits(r *http.Request, limits structs.StreamLimits, bodyTooLarge bool) int {
+	if limits.MaxHeaderListSize > 0 && headerListSize(r) > limits.MaxHeaderListSize {
+		return http.StatusRequestHeaderFieldsTooLarge
+	}
+	if limits.MaxURILength > 0 && len(r.RequestURI) > limits.MaxURILength {
+		return http.StatusRequestURITooLong
+	}
+	if bodyTooLarge {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return 0
+}
+
+// checkExpectation returns the status an Expect header is answered with before
+// any DATA frame is read: 100 Continue if the stream would be served, the
+// final rejection status otherwise.
+func checkExpectation(r *http.Request, router chi.Router, limits structs.StreamLimits) int {
+	if !strings.EqualFold(strings.TrimSpace(r.Header.Get("Expect")), "100-continue") {
+		return http.StatusExpectationFailed
+	}
+	if r.URL == nil || !router.Match(chi.NewRouteContext(), r.Method, r.URL.Path) {
+		return http.StatusNotFound
+	}
+
+	contentLength, err := strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64)
+	bodyTooLarge := err == nil && limits.MaxBodyBytes > 0 && contentLength > limits.MaxBodyBytes
+	if statusCode := exceedsLimits(r, limits, bodyTooLarge); statusCode != 0 {
+		return statusCode
+	}
+
+	// The route decides with the announced length, the body isn't there yet
+	if limits.CheckRoute != nil {
+		if err == nil {
+			r.ContentLength = contentLength
+		}
+		if statusCode := limits.CheckRoute(r); statusCode != 0 {
+			return statusCode
+		}
+	}
+
+	return http.StatusContinue
+}
+
+func HandleMultiplexedFrameParsing(comm *structs.Communication, router chi.Router, conn *tls.Conn, respEssential structs.ResponseEssential, limits structs.StreamLimits) {
+	r := &http.Request{RemoteAddr: conn.RemoteAddr().String()}
 	var bodyContent string
+	var bodyTooLarge bool
 	var streamID uint32
+	var responseWriter *http2.Response
+	var answered bool
 
 	dec := comm.Dec
 
+	// However the stream ends, its frames are no longer read
+	receiving := true
+	finishReceiving := func() {
+		if !receiving {
+			return
+		}
+		receiving = false
+		close(comm.Frames)
+		if comm.Received != nil {
+			comm.Received()
+		}
+	}
+	defer finishReceiving()
+
 Loop:
 	for frame := range comm.Frames {
 		if streamID == 0 {
@@ -190,9 +278,24 @@ Loop:
 		case structs.HEADER_FRAME_TYPE:
 			moreFrames, err := parseHeaderFrame(frame, r, *dec, comm.Mutex)
 			if err != nil {
-				fmt.Println("cannot parse header frame")
+				respEssential.Logger.Log(logging.LogLevelWarn, "Cannot parse header frame of stream %d from %v: %v", streamID, conn.RemoteAddr(), err)
 				return
 			}
+			if frame.Flags&structs.END_HEADERS != 0 && r.Header.Get("Expect") != "" {
+				responseWriter = http2.NewResponse(conn, streamID, respEssential)
+				responseWriter.SetRequest(r)
+				statusCode := checkExpectation(r, router, limits)
+				if statusCode == http.StatusContinue {
+					if moreFrames {
+						responseWriter.WriteHeader(http.StatusContinue)
+					}
+				} else if moreFrames || statusCode == http.StatusExpectationFailed {
+					// Reject before the body is sent, the remaining frames are only consumed
+					responseWriter.WriteHeader(statusCode)
+					responseWriter.Finish()
+					answered = true
+				}
+			}
 			if !moreFrames {
 				break Loop
 			}
@@ -201,9 +304,16 @@ Loop:
 		case structs.DATA_FRAME_TYPE:
 			moreFrames, err := parseDataFrame(frame, &bodyContent)
 			if err != nil {
-				fmt.Printf("cannot parse frame content: %v", err)
+				respEssential.Logger.Log(logging.LogLevelWarn, "Cannot parse data frame of stream %d from %v: %v", streamID, conn.RemoteAddr(), err)
 				return
 			}
+			// Keep consuming the stream, but stop buffering a body that is already too large or rejected
+			if answered {
+				bodyContent = ""
+			} else if limits.MaxBodyBytes > 0 && int64(len(bodyContent)) > limits.MaxBodyBytes {
+				bodyTooLarge = true
+				bodyContent = ""
+			}
 			if !moreFrames {
 				break Loop
 			}
@@ -212,11 +322,23 @@ Loop:
 			continue
 		}
 	}
-	close(comm.Frames)
+	finishReceiving()
 
-	r.Body = io.NopCloser(strings.NewReader(bodyContent))
-	responseWriter := http2.NewResponse(conn, streamID, respEssential)
-	router.ServeHTTP(responseWriter, r)
+	if answered {
+		return
+	}
+
+	if responseWriter == nil {
+		responseWriter = http2.NewResponse(conn, streamID, respEssential)
+		responseWriter.SetRequest(r)
+	}
+	if statusCode := exceedsLimits(r, limits, bodyTooLarge); statusCode != 0 {
+		responseWriter.WriteHeader(statusCode)
+	} else {
+		r.Body = io.NopCloser(strings.NewReader(bodyContent))
+		r.ContentLength = int64(len(bodyContent))
+		router.ServeHTTP(responseWriter, r)
+	}
 
-	respEssential.FrameChan <- frame.NewFrame(structs.DATA_FRAME_TYPE, structs.END_STREAM, streamID, nil)
+	responseWriter.Finish()
 }
@@ -0,0 +1,207 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"httpServer/internal/logging"
+)
+
+type entry struct {
+	mutex     sync.Mutex
+	addresses []string
+	expires   time.Time
+	// next is where the following connection starts in addresses
+	next atomic.Uint64
+}
+
+// Resolver looks up the addresses of the upstream host names of a route and
+// looks them up again once they are older than its refresh interval, so DNS
+// changes are picked up while the proxy runs. Once started it also looks them
+// up in the background, so routes that only reuse pooled connections notice
+// changes too.
+type Resolver struct {
+	route   string
+	refresh time.Duration
+	logger  logging.Logger
+	// Lookup queries DNS, net.DefaultResolver unless replaced.
+	Lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	mutex    sync.Mutex
+	hosts    map[string]*entry
+	onChange func()
+	cancel   context.CancelFunc
+	wait     sync.WaitGroup
+}
+
+func New(route string, refresh time.Duration, logger logging.Logger) *Resolver {
+	return &Resolver{
+		route:   route,
+		refresh: refresh,
+		logger:  logger,
+		Lookup:  net.DefaultResolver.LookupIPAddr,
+		hosts:   make(map[string]*entry),
+	}
+}
+
+// OnChange calls f whenever the addresses of a host changed.
+func (r *Resolver) OnChange(f func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.onChange = f
+}
+
+// Start looks up the known host names again every refresh interval until Stop is called.
+func (r *Resolver) Start() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.cancel != nil || r.refresh <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	r.wait.Add(1)
+	go r.run(ctx)
+}
+
+// Stop ends looking up in the background and waits for lookups in progress.
+func (r *Resolver) Stop() {
+	r.mutex.Lock()
+	cancel := r.cancel
+	r.cancel = nil
+	r.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+		r.wait.Wait()
+	}
+}
+
+func (r *Resolver) run(ctx context.Context) {
+	defer r.wait.Done()
+
+	ticker := time.NewTicker(r.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		r.mutex.Lock()
+		hosts := make(map[string]*entry, len(r.hosts))
+		for host, e := range r.hosts {
+			hosts[host] = e
+		}
+		r.mutex.Unlock()
+
+		for host, e := range hosts {
+			e.mutex.Lock()
+			// Hosts that never resolved are left to the next dial
+			if len(e.addresses) > 0 {
+				_ = r.update(ctx, host, e)
+			}
+			e.mutex.Unlock()
+		}
+	}
+}
+
+// Addresses returns the addresses of host, each call starting at the next one.
+// If they can't be looked up again the previous addresses are kept.
+func (r *Resolver) Addresses(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	r.mutex.Lock()
+	e, ok := r.hosts[host]
+	if !ok {
+		e = &entry{}
+		r.hosts[host] = e
+	}
+	r.mutex.Unlock()
+
+	e.mutex.Lock()
+	if time.Now().After(e.expires) {
+		if err := r.update(ctx, host, e); err != nil {
+			e.mutex.Unlock()
+			return nil, err
+		}
+	}
+	addresses := e.addresses
+	e.mutex.Unlock()
+
+	start := int(e.next.Add(1)-1) % len(addresses)
+	return append(slices.Clone(addresses[start:]), addresses[:start]...), nil
+}
+
+// update looks up host again, e has to be locked.
+func (r *Resolver) update(ctx context.Context, host string, e *entry) error {
+	found, err := r.Lookup(ctx, host)
+	if err == nil && len(found) == 0 {
+		err = &net.DNSError{Err: "no addresses found", Name: host, IsNotFound: true}
+	}
+	if err != nil {
+		if ctx.Err() != nil || len(e.addresses) == 0 {
+			return err
+		}
+		r.logger.Log(logging.LogLevelWarn, "Failed to look up %s of route %s again, keeping %v: %v", host, r.route, e.addresses, err)
+		e.expires = time.Now().Add(r.refresh)
+		return nil
+	}
+
+	addresses := make([]string, 0, len(found))
+	for _, address := range found {
+		addresses = append(addresses, address.String())
+	}
+	slices.Sort(addresses)
+	addresses = slices.Compact(addresses)
+
+	changed := e.addresses != nil && !slices.Equal(addresses, e.addresses)
+	e.addresses = addresses
+	e.expires = time.Now().Add(r.refresh)
+
+	if changed {
+		r.logger.Log(logging.LogLevelInfo, "Addresses of %s on route %s changed to %v", host, r.route, addresses)
+		r.mutex.Lock()
+		onChange := r.onChange
+		r.mutex.Unlock()
+		if onChange != nil {
+			onChange()
+		}
+	}
+	return nil
+}
+
+// DialContext connects to addr with dialer, trying the addresses of its host
+// in turn. Every connection starts at another address, so they are spread
+// over all of them.
+func (r *Resolver) DialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addresses, err := r.Addresses(ctx, host)
+		if err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+		}
+
+		var conn net.Conn
+		for _, address := range addresses {
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(address, port))
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+		}
+		return conn, err
+	}
+}
@@ -1,27 +1,45 @@
 package http1_1
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"httpServer/internal/response"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
+var ErrResponseFinished = errors.New("http: write on a finished response")
+var ErrNotHijackable = errors.New("http: connection of this response can't be hijacked")
+
 type Response struct {
 	header             http.Header
-	body               []byte
+	pending            []byte // Body written before the framing is decided
 	connection         net.Conn
+	writer             *bufio.Writer
+	reader             *bufio.Reader
+	request            *http.Request
+	options            response.Options
+	statusCode         int
 	headerWritten      bool
+	chunked            bool
+	finished           bool
+	hijacked           bool
 	preventFutureReads bool
 }
 
+// CONTENTSIZEMIN is how much of a body is buffered to send it with a Content-Length.
+// Larger bodies of unknown length are chunked.
 const CONTENTSIZEMIN = 1024 * 5
 
 func NewResponse(conn net.Conn) *Response {
 	res := &Response{
 		header:             http.Header{},
 		connection:         conn,
+		writer:             bufio.NewWriter(conn),
 		headerWritten:      false,
 		preventFutureReads: false,
 	}
@@ -29,60 +47,276 @@ func NewResponse(conn net.Conn) *Response {
 	return res
 }
 
+// SetRequest tells the response which request it answers, HTTP/1.0 clients
+// can't receive chunked bodies.
+func (r *Response) SetRequest(req *http.Request) {
+	r.request = req
+}
+
+// SetOptions configures the header fields added to the final response.
+func (r *Response) SetOptions(options response.Options) {
+	r.options = options
+}
+
+// SetReader provides the buffered reader of the connection, which a handler
+// hijacking the connection takes over. Responses without one can't be hijacked.
+func (r *Response) SetReader(reader *bufio.Reader) {
+	r.reader = reader
+}
+
+// Hijack hands the connection over to the handler, for example to tunnel a
+// protocol after 101 Switching Protocols. The response can't be written afterwards.
+func (r *Response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if r.hijacked {
+		return nil, nil, http.ErrHijacked
+	}
+	if r.reader == nil || r.finished {
+		return nil, nil, ErrNotHijackable
+	}
+
+	if err := r.writer.Flush(); err != nil {
+		return nil, nil, err
+	}
+	r.hijacked = true
+	r.finished = true
+	// The new owner of the connection sets its own deadlines
+	_ = r.connection.SetDeadline(time.Time{})
+
+	return r.connection, bufio.NewReadWriter(r.reader, bufio.NewWriter(r.connection)), nil
+}
+
+// Hijacked reports whether the handler took over the connection.
+func (r *Response) Hijacked() bool {
+	return r.hijacked
+}
+
 func (r *Response) Header() http.Header {
 	return r.header
 }
 
 func (r *Response) Write(data []byte) (int, error) {
-	if !r.headerWritten {
+	if r.hijacked {
+		return 0, http.ErrHijacked
+	}
+	if r.finished {
+		return 0, ErrResponseFinished
+	}
+
+	if r.statusCode == 0 {
 		length := min(len(data), 512)
 
 		if r.Header().Get("Content-Type") == "" {
 			r.Header().Set("Content-Type", http.DetectContentType(data[:length]))
 		}
-		if len(data) < CONTENTSIZEMIN {
-			r.header.Set("Content-Length", strconv.Itoa(len(data)))
-		}
 
 		r.WriteHeader(http.StatusOK)
 	}
 
 	r.preventFutureReads = true
-	r.body = append(r.body, data...)
-	wrote, err := r.connection.Write(data)
-	if err != nil {
-		return 0, err
+
+	if !r.headerWritten {
+		r.pending = append(r.pending, data...)
+		if len(r.pending) < CONTENTSIZEMIN {
+			return len(data), nil
+		}
+
+		if err := r.commit(false); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+
+	return r.writeBody(data)
+}
+
+// writeInterim sends a 1xx response. 100 Continue goes out bare, other
+// informational responses carry the current header fields (103 Early Hints).
+func (r *Response) writeInterim(statusCode int) {
+	var interim strings.Builder
+	interim.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, http.StatusText(statusCode)))
+	if statusCode != http.StatusContinue {
+		for key, values := range r.header {
+			interim.WriteString(fmt.Sprintf("%s: %s\r\n", key, strings.Join(values, ", ")))
+		}
 	}
+	interim.WriteString("\r\n")
 
-	return wrote, nil
+	// The client may be waiting for it before it goes on
+	_, _ = r.writer.WriteString(interim.String())
+	_ = r.writer.Flush()
 }
 
+// WriteHeader records the final status. The header section is sent once the
+// body framing is known, on the first large write or when the response is finished.
 func (r *Response) WriteHeader(statusCode int) {
-	if statusCode < 100 || statusCode >= 600 || r.headerWritten {
+	if statusCode < 100 || statusCode >= 600 || r.statusCode != 0 || r.hijacked {
+		return
+	}
+
+	if statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		r.writeInterim(statusCode)
 		return
 	}
 
-	var responseLine = fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, http.StatusText(statusCode))
+	r.statusCode = statusCode
+	if !response.BodyAllowed(statusCode) {
+		_ = r.commit(true)
+	}
+}
+
+// commit decides the body framing and sends the header section with the buffered body.
+// complete is set when the whole body is buffered.
+func (r *Response) commit(complete bool) error {
+	response.Finalize(r.header, r.statusCode, r.options)
+	trailers := response.TrailerNames(r.header)
+
+	switch {
+	case !response.SendsBody(r.request, r.statusCode):
+		// A response to HEAD still announces the length of what a GET would have sent
+		if complete && response.BodyAllowed(r.statusCode) && r.header.Get("Content-Length") == "" && len(r.pending) > 0 {
+			r.header.Set("Content-Length", strconv.Itoa(len(r.pending)))
+		}
+	case r.header.Get("Content-Length") != "":
+		// The handler knows the length
+	case complete && len(trailers) == 0:
+		r.header.Set("Content-Length", strconv.Itoa(len(r.pending)))
+	case r.request != nil && !r.request.ProtoAtLeast(1, 1):
+		// The end of the body can only be signalled by closing the connection
+		r.header.Set("Connection", "close")
+	default:
+		r.header.Set("Transfer-Encoding", "chunked")
+		r.chunked = true
+	}
+	response.KeepAlive(r.request, r.header)
+
+	var responseLine = fmt.Sprintf("HTTP/1.1 %d %s\r\n", r.statusCode, http.StatusText(r.statusCode))
 	// Check if wrote correct
-	_, err := r.connection.Write([]byte(responseLine))
+	_, err := r.writer.WriteString(responseLine)
 	if err != nil {
-		return
+		return err
 	}
 
 	for key, values := range r.header {
+		if trailers[key] {
+			continue
+		}
+
 		headerEntry := fmt.Sprintf("%s: %s\r\n", key, strings.Join(values, ", "))
-		fmt.Print(headerEntry)
-		_, err := r.connection.Write([]byte(headerEntry))
+		_, err := r.writer.WriteString(headerEntry)
 		if err != nil {
-			return
+			return err
 		}
 	}
 
-	_, err = r.connection.Write([]byte("\r\n"))
+	_, err = r.writer.WriteString("\r\n")
 	if err != nil {
-		fmt.Printf("Error writing response: %v\n", err)
-		return
+		return err
 	}
 
 	r.headerWritten = true
+
+	pending := r.pending
+	r.pending = nil
+	if len(pending) > 0 {
+		_, err = r.writeBody(pending)
+	}
+
+	return err
+}
+
+func (r *Response) writeBody(data []byte) (int, error) {
+	if !response.SendsBody(r.request, r.statusCode) {
+		if response.BodyAllowed(r.statusCode) {
+			return len(data), nil
+		}
+		return 0, http.ErrBodyNotAllowed
+	}
+	if !r.chunked {
+		return r.writer.Write(data)
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	if _, err := r.writer.WriteString(strconv.FormatInt(int64(len(data)), 16) + "\r\n"); err != nil {
+		return 0, err
+	}
+	if _, err := r.writer.Write(data); err != nil {
+		return 0, err
+	}
+	if _, err := r.writer.WriteString("\r\n"); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// Finish completes the response after the handler returned. A body that fit
+// into the buffer is sent with its Content-Length, a chunked body gets its
+// last chunk and trailer section.
+func (r *Response) Finish() error {
+	if r.finished {
+		return nil
+	}
+
+	if r.statusCode == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.headerWritten {
+		if err := r.commit(true); err != nil {
+			r.finished = true
+			return err
+		}
+	}
+	r.finished = true
+
+	if r.chunked {
+		var lastChunk strings.Builder
+		lastChunk.WriteString("0\r\n")
+		for name := range response.TrailerNames(r.header) {
+			values := r.header.Values(name)
+			if len(values) == 0 {
+				continue
+			}
+			lastChunk.WriteString(fmt.Sprintf("%s: %s\r\n", strings.TrimPrefix(name, http.TrailerPrefix), strings.Join(values, ", ")))
+		}
+		lastChunk.WriteString("\r\n")
+
+		if _, err := r.writer.WriteString(lastChunk.String()); err != nil {
+			return err
+		}
+	}
+
+	return r.writer.Flush()
+}
+
+// Flush sends the header section and everything written so far. A body of
+// unknown length is chunked from then on.
+func (r *Response) Flush() {
+	if r.finished {
+		return
+	}
+
+	if r.statusCode == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.headerWritten {
+		if err := r.commit(false); err != nil {
+			return
+		}
+	}
+
+	_ = r.writer.Flush()
+}
+
+// Abort ends a response whose body can't be completed. The connection has to
+// be closed so the client doesn't take the truncated body for a complete one.
+func (r *Response) Abort() {
+	if r.finished {
+		return
+	}
+
+	_ = r.writer.Flush()
+	r.finished = true
+	r.header.Set("Connection", "close")
 }
@@ -0,0 +1,24 @@
+package http2
+
+import (
+	"encoding/binary"
+	"httpServer/internal/http2/frame"
+	"httpServer/internal/http2/structs"
+)
+
+//goland:noinspection ALL
+const (
+	ERROR_CODE_NO_ERROR = iota
+	ERROR_CODE_PROTOCOL_ERROR
+	ERROR_CODE_INTERNAL_ERROR
+)
+
+// SendGoAway announces that no stream after lastStreamID will be processed, the
+// client opens a new connection for further requests.
+func SendGoAway(essential structs.ResponseEssential, lastStreamID uint32, errorCode uint32) {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint32(payload[:4], lastStreamID&^(1<<31))
+	binary.BigEndian.PutUint32(payload[4:], errorCode)
+
+	sendFrame(essential, frame.NewFrame(structs.GOAWAY_FRAME_TYPE, 0, 0, payload))
+}
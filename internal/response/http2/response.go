@@ -3,10 +3,13 @@ package http2
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	hpack "github.com/tatsuhiro-t/go-http2-hpack"
 	"httpServer/internal/http2/frame"
 	"httpServer/internal/http2/structs"
+	"httpServer/internal/logging"
+	"httpServer/internal/response"
 	"net"
 	"net/http"
 	"strconv"
@@ -15,19 +18,29 @@ import (
 
 type Response struct {
 	header             http.Header
-	body               []byte
 	essential          structs.ResponseEssential
+	request            *http.Request
 	lastStreamID       uint32
+	statusCode         int
 	headerWritten      bool
+	finished           bool
+	goAway             bool
 	preventFutureReads bool
 	maxTableSze        int
 }
 
+var ErrResponseFinished = errors.New("http2: write on a finished response")
+
 const CONTENT_SIZE_MIN = 1_024 * 5
-const MAX_DATA_BODY_LENGTH = 16_000_000
+
+// MAX_DATA_BODY_LENGTH is the default SETTINGS_MAX_FRAME_SIZE, no DATA frame may be larger.
+const MAX_DATA_BODY_LENGTH = 16_384
 
 const MAX_HEADER_BODY_LENGTH = 8_000
 
+// connectionSpecificHeaders must not appear in HTTP/2 messages (RFC 9113 Section 8.2.2).
+var connectionSpecificHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade"}
+
 func SendFrame(conn net.Conn, iType uint8, flags uint8, streamID uint32, data []byte) error {
 	var message bytes.Buffer
 
@@ -64,6 +77,11 @@ func NewResponse(conn net.Conn, streamID uint32, essential structs.ResponseEssen
 	}
 }
 
+// SetRequest tells the response which request it answers, responses to HEAD carry no DATA.
+func (r *Response) SetRequest(req *http.Request) {
+	r.request = req
+}
+
 func (r *Response) SetMaxTableSize(s int) {
 	r.maxTableSze = s
 }
@@ -73,66 +91,189 @@ func (r *Response) Header() http.Header {
 }
 
 func (r *Response) Write(data []byte) (int, error) {
-	if !r.headerWritten {
+	if r.finished {
+		return 0, ErrResponseFinished
+	}
+
+	if r.statusCode == 0 {
 		length := min(len(data), 512)
 
 		if r.Header().Get("Content-Type") == "" {
 			r.Header().Set("Content-Type", http.DetectContentType(data[:length]))
 		}
-		if len(data) < CONTENT_SIZE_MIN {
-			r.header.Set("Content-Length", strconv.Itoa(len(data)))
-		}
 
 		r.WriteHeader(http.StatusOK)
 	}
 
 	r.preventFutureReads = true
 
-	r.body = append(r.body, data...)
+	if !response.SendsBody(r.request, r.statusCode) {
+		if response.BodyAllowed(r.statusCode) {
+			return len(data), nil
+		}
+		return 0, http.ErrBodyNotAllowed
+	}
+	if !r.headerWritten {
+		r.writeHeaders(r.statusCode, false)
+	}
 
-	var wrote int
-	var toWrite = len(r.body)
+	for wrote := 0; wrote < len(data); wrote += MAX_DATA_BODY_LENGTH {
+		end := min(wrote+MAX_DATA_BODY_LENGTH, len(data))
+		if !sendFrame(r.essential, frame.NewFrame(structs.DATA_FRAME_TYPE, 0x00, r.lastStreamID, data[wrote:end])) {
+			return wrote, net.ErrClosed
+		}
+	}
+
+	return len(data), nil
+}
 
-	for toWrite != 0 {
-		if toWrite > MAX_DATA_BODY_LENGTH {
-			r.essential.FrameChan <- frame.NewFrame(structs.DATA_FRAME_TYPE, 0x00, r.lastStreamID, data[wrote:wrote+MAX_DATA_BODY_LENGTH])
-			toWrite -= MAX_DATA_BODY_LENGTH
-			wrote += MAX_DATA_BODY_LENGTH
-		} else {
-			r.essential.FrameChan <- frame.NewFrame(structs.DATA_FRAME_TYPE, structs.END_STREAM, r.lastStreamID, data[wrote:toWrite])
-			wrote += toWrite
-			toWrite -= toWrite
+// sendHeaderBlock encodes headers and sends them as one HEADERS frame.
+func (r *Response) sendHeaderBlock(headers []*hpack.Header, endStream bool) {
+	var encodedHeaders bytes.Buffer
+	r.essential.Enc.Encode(&encodedHeaders, headers)
+
+	flags := uint8(structs.END_HEADERS)
+	if endStream {
+		flags |= structs.END_STREAM
+	}
+	sendFrame(r.essential, frame.NewFrame(structs.HEADER_FRAME_TYPE, flags, r.lastStreamID, encodedHeaders.Bytes()))
+}
+
+// writeHeaders sends statusCode and the header fields, trailer fields are held back for Finish.
+func (r *Response) writeHeaders(statusCode int, endStream bool) {
+	var headers []*hpack.Header
+
+	headers = append(headers, &hpack.Header{Name: ":status", Value: strconv.Itoa(statusCode)})
+
+	if statusCode != http.StatusContinue {
+		trailers := response.TrailerNames(r.header)
+		for key, values := range r.header {
+			if trailers[key] {
+				continue
+			}
+			for _, value := range values {
+				headers = append(headers, &hpack.Header{Name: strings.ToLower(key), Value: value})
+			}
 		}
 	}
 
-	return wrote, nil
+	r.sendHeaderBlock(headers, endStream)
+
+	if statusCode >= 200 {
+		r.headerWritten = true
+	}
 }
 
+// trailerFields returns the trailer fields that got a value while the body was written.
+func (r *Response) trailerFields() []*hpack.Header {
+	var trailers []*hpack.Header
+	for name := range response.TrailerNames(r.header) {
+		for _, value := range r.header.Values(name) {
+			trailers = append(trailers, &hpack.Header{Name: strings.ToLower(strings.TrimPrefix(name, http.TrailerPrefix)), Value: value})
+		}
+	}
+
+	return trailers
+}
+
+// WriteHeader sends informational responses right away as additional HEADERS
+// frames. The final status is sent with the first DATA or when the response is finished.
 func (r *Response) WriteHeader(statusCode int) {
-	var headers []*hpack.Header
+	if statusCode < 100 || statusCode >= 600 || r.statusCode != 0 {
+		return
+	}
 
-	for key, values := range r.header {
-		for _, value := range values {
-			headers = append(headers, &hpack.Header{Name: strings.ToLower(key), Value: value})
+	if statusCode < 200 {
+		// HTTP/2 has no protocol switch, 101 can't be sent
+		if statusCode != http.StatusSwitchingProtocols {
+			r.writeHeaders(statusCode, false)
 		}
+		return
 	}
 
-	headers = append(headers, &hpack.Header{Name: ":status", Value: strconv.Itoa(statusCode)})
+	r.statusCode = statusCode
+	response.Finalize(r.header, statusCode, r.essential.Options)
 
-	var encodedHeaders bytes.Buffer
-	r.essential.Enc.Encode(&encodedHeaders, headers)
+	// The connection is closed gracefully with GOAWAY instead of a header field
+	r.goAway = !response.KeepAlive(r.request, r.header)
+	for _, name := range connectionSpecificHeaders {
+		r.header.Del(name)
+	}
+}
+
+// Finish ends the stream after the handler returned, with a trailer section if one was declared.
+func (r *Response) Finish() {
+	if r.finished {
+		return
+	}
+
+	if r.statusCode == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.finished = true
+
+	if !r.headerWritten {
+		r.writeHeaders(r.statusCode, true)
+	} else if trailers := r.trailerFields(); len(trailers) > 0 {
+		r.sendHeaderBlock(trailers, true)
+	} else {
+		sendFrame(r.essential, frame.NewFrame(structs.DATA_FRAME_TYPE, structs.END_STREAM, r.lastStreamID, nil))
+	}
 
-	r.essential.FrameChan <- frame.NewFrame(structs.HEADER_FRAME_TYPE, structs.END_HEADERS, r.lastStreamID, encodedHeaders.Bytes())
+	if r.goAway {
+		SendGoAway(r.essential, r.lastStreamID, ERROR_CODE_NO_ERROR)
+	}
+}
+
+// Flush sends the final HEADERS frame if the handler didn't write yet, DATA
+// frames are never held back.
+func (r *Response) Flush() {
+	if r.finished {
+		return
+	}
+
+	if r.statusCode == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.headerWritten {
+		r.writeHeaders(r.statusCode, false)
+	}
+}
+
+// Abort resets a stream whose body can't be completed, so the client doesn't
+// take the truncated body for a complete one.
+func (r *Response) Abort() {
+	if r.finished {
+		return
+	}
+	r.finished = true
 
-	r.headerWritten = true
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, ERROR_CODE_INTERNAL_ERROR)
+	sendFrame(r.essential, frame.NewFrame(structs.RST_STREAM_FRAME_TYPE, 0, r.lastStreamID, payload))
 }
 
+// SendFrames writes the frames of all streams to the connection. Once a write
+// fails the connection is closed and Sent tells the streams to stop sending.
 func SendFrames(essential structs.ResponseEssential) {
+	defer close(essential.Sent)
+
 	for f := range essential.FrameChan {
 		err := SendFrame(essential.Connection, f.Type, f.Flags, f.StreamID, f.Payload)
 		if err != nil {
-			fmt.Printf("send frame failed: %v\n", err)
+			essential.Logger.Log(logging.LogLevelWarn, "Failed to send HTTP/2 frame to %v, closing the connection: %v", essential.Connection.RemoteAddr(), err)
+			_ = essential.Connection.Close()
 			return
 		}
 	}
 }
+
+// sendFrame hands f to the frame writer, false if it stopped already.
+func sendFrame(essential structs.ResponseEssential, f *structs.Frame) bool {
+	select {
+	case essential.FrameChan <- f:
+		return true
+	case <-essential.Sent:
+		return false
+	}
+}
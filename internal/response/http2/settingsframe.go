@@ -24,12 +24,21 @@ const (
 var ConnectionPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
 var parseNewFrame = fmt.Errorf("got window_update frame, need settings frame")
 
-func SendSettingsFrame(conn net.Conn) error {
+// SendSettingsFrame announces our settings. A maxHeaderListSize of 0 leaves
+// SETTINGS_MAX_HEADER_LIST_SIZE out, which means unlimited.
+func SendSettingsFrame(conn net.Conn, maxHeaderListSize uint32) error {
 	// Construct settings data
 	data := make([]byte, 6)
 	binary.BigEndian.PutUint16(data[:2], uint16(SETTINGS_HEADER_TABLE_SIZE))
 	binary.BigEndian.PutUint32(data[2:], 4096)
 
+	if maxHeaderListSize != 0 {
+		setting := make([]byte, 6)
+		binary.BigEndian.PutUint16(setting[:2], uint16(SETTINGS_MAX_HEADER_LIST_SIZE))
+		binary.BigEndian.PutUint32(setting[2:], maxHeaderListSize)
+		data = append(data, setting...)
+	}
+
 	err := SendFrame(conn, structs.SETTINGS_FRAME_TYPE, 0, 0, data)
 	if err != nil {
 		return fmt.Errorf("error writing settings frame: %w", err)
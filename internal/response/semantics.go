@@ -0,0 +1,110 @@
+// Package response holds the response semantics of RFC 9110 shared by the
+// HTTP/1.1 and HTTP/2 response writers.
+package response
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Options configure the header fields the proxy adds to every final response.
+type Options struct {
+	// Server is sent as Server header unless the handler set one, empty omits it.
+	Server string
+	// Via is the pseudonym the proxy adds itself to the Via header with, empty omits it.
+	Via string
+}
+
+// BodyAllowed reports whether a response with statusCode can carry content (RFC 9110 Section 6.4.1).
+func BodyAllowed(statusCode int) bool {
+	return statusCode >= 200 && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
+
+// SendsBody reports whether the content of a response is sent to the client,
+// responses to HEAD only announce it.
+func SendsBody(req *http.Request, statusCode int) bool {
+	return BodyAllowed(statusCode) && (req == nil || req.Method != http.MethodHead)
+}
+
+// Finalize adds the header fields every final response carries before its
+// header section is sent: Date, and Server and Via when configured.
+func Finalize(header http.Header, statusCode int, options Options) {
+	if header.Get("Date") == "" {
+		header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if options.Server != "" && header.Get("Server") == "" {
+		header.Set("Server", options.Server)
+	}
+	if options.Via != "" {
+		header.Add("Via", "1.1 "+options.Via)
+	}
+
+	// A 304 may still describe the length of the selected representation
+	if statusCode < 200 || statusCode == http.StatusNoContent {
+		header.Del("Content-Length")
+	}
+	if !BodyAllowed(statusCode) {
+		header.Del("Transfer-Encoding")
+	}
+}
+
+// TrailerNames returns the canonical names of the fields sent after the body:
+// the ones declared in the Trailer header and those set with http.TrailerPrefix.
+func TrailerNames(header http.Header) map[string]bool {
+	names := make(map[string]bool)
+
+	for _, declared := range header.Values("Trailer") {
+		for _, name := range strings.Split(declared, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names[http.CanonicalHeaderKey(name)] = true
+			}
+		}
+	}
+	for key := range header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			names[key] = true
+		}
+	}
+
+	return names
+}
+
+// hasToken reports whether one of the comma separated values of name in header is token.
+func hasToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, element := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(element), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// KeepAlive decides whether the connection stays open after the response to
+// req and records the decision in the Connection header. The connection is
+// closed if the handler or the client ask for it, or if an HTTP/1.0 client
+// didn't ask for keep-alive.
+func KeepAlive(req *http.Request, header http.Header) bool {
+	closing := hasToken(header, "Connection", "close")
+	http10 := req != nil && !req.ProtoAtLeast(1, 1)
+
+	if req != nil {
+		closing = closing || hasToken(req.Header, "Connection", "close")
+		if http10 && !hasToken(req.Header, "Connection", "keep-alive") {
+			closing = true
+		}
+	}
+
+	if closing {
+		header.Set("Connection", "close")
+		return false
+	}
+	if http10 {
+		header.Set("Connection", "keep-alive")
+	}
+
+	return true
+}
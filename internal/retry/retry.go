@@ -0,0 +1,124 @@
+package retry
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Policy decides which failed requests of a route are sent again.
+type Policy struct {
+	// Attempts is how often a request is retried after its first attempt.
+	Attempts int
+	// Statuses are the upstream statuses that are retried.
+	Statuses map[int]bool
+	// ConnectErrors retries requests whose upstream couldn't be connected to.
+	ConnectErrors bool
+	// Timeouts retries requests that timed out.
+	Timeouts bool
+	// BackoffBase is the longest wait before the first retry, it doubles with
+	// every further retry up to BackoffMax.
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// MaxBodyBytes is the largest request body kept to be sent again, requests
+	// with larger bodies aren't retried.
+	MaxBodyBytes int64
+	Budget       *Budget
+}
+
+// Retryable reports whether an attempt that ended with resp or err may be
+// retried. Only idempotent requests are retried once they may have reached the
+// upstream, others only if it couldn't be connected to.
+func (p *Policy) Retryable(idempotent bool, resp *http.Response, err error) bool {
+	switch {
+	case err == nil:
+		return idempotent && p.Statuses[resp.StatusCode]
+	case ConnectError(err):
+		return p.ConnectErrors
+	case Timeout(err):
+		return idempotent && p.Timeouts
+	}
+	return false
+}
+
+// Backoff returns how long to wait before the given retry, counted from zero.
+// The wait is random up to the exponential bound, so that requests failing at
+// the same time don't come back at the same time.
+func (p *Policy) Backoff(retry int) time.Duration {
+	bound := p.BackoffBase
+	for i := 0; i < retry && bound < p.BackoffMax; i++ {
+		bound *= 2
+	}
+	bound = min(bound, p.BackoffMax)
+	if bound <= 0 {
+		return 0
+	}
+
+	return rand.N(bound + 1)
+}
+
+// ConnectError reports whether err happened while connecting, before any of the request was sent.
+func ConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func Timeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// budgetBurst is the most retries a budget saves up.
+const budgetBurst = 10
+
+// Budget bounds retries to a share of the requests of a route, so retries
+// can't multiply the load on upstreams that are already failing. A few retries
+// per second are allowed regardless, so routes with little traffic still retry.
+type Budget struct {
+	ratio        float64
+	minPerSecond float64
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewBudget(percent int, minPerSecond int) *Budget {
+	return &Budget{
+		ratio:        float64(percent) / 100,
+		minPerSecond: float64(minPerSecond),
+		tokens:       budgetBurst,
+		last:         time.Now(),
+	}
+}
+
+// Deposit counts a request towards the budget.
+func (b *Budget) Deposit() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	b.tokens = min(b.tokens+b.ratio, budgetBurst)
+}
+
+// Withdraw reports whether the budget allows another retry and takes it from the budget.
+func (b *Budget) Withdraw() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *Budget) refill() {
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.minPerSecond, budgetBurst)
+	b.last = now
+}
@@ -1,21 +1,237 @@
 package reverseproxy
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"gopkg.in/yaml.v2"
+	"httpServer/internal/balancer"
+	"httpServer/internal/breaker"
+	"httpServer/internal/handler"
+	"httpServer/internal/health"
+	"httpServer/internal/retry"
+	"httpServer/internal/reverseproxy/structs"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	DefaultMaxURILength   = 8 * 1024
+	DefaultMaxHeaderCount = 100
+	DefaultMaxHeaderBytes = 64 * 1024
+
+	DefaultMaxPipelinedRequests = 8
+
+	DefaultUpgradeIdleTimeout = 300
+
+	DefaultRedirectStatus = http.StatusPermanentRedirect
+
+	DefaultHandshakeTimeout  = 10
+	DefaultReadHeaderTimeout = 10
+	DefaultReadBodyTimeout   = 60
+	DefaultWriteTimeout      = 30
+	DefaultIdleTimeout       = 120
+
+	DefaultHealthCheckInterval = 10
+	DefaultHealthCheckTimeout  = 2
+	DefaultHealthyThreshold    = 2
+	DefaultUnhealthyThreshold  = 3
+
+	DefaultBaseEjectionTime = 30
+	DefaultMaxEjectionTime  = 300
+
+	DefaultRetryBackoffBase    = 25
+	DefaultRetryBackoffMax     = 250
+	DefaultRetryBudgetPercent  = 20
+	DefaultMinRetriesPerSecond = 3
+	DefaultRetryMaxBodyBytes   = 64 * 1024
+
+	DefaultConnectTimeout        = 10
+	DefaultTLSHandshakeTimeout   = 10
+	DefaultResponseHeaderTimeout = 60
+	DefaultBodyIdleTimeout       = 60
+
+	DefaultDNSRefresh = 30
+
+	DefaultMaxIdleConnections        = 100
+	DefaultMaxIdleConnectionsPerHost = 16
+	DefaultIdleConnectionTimeout     = 90
+)
+
+const (
+	RetryOnConnectError = "connect_error"
+	RetryOnTimeout      = "timeout"
+)
+
+// DefaultRetryOn are the conditions retried if a route doesn't name any.
+var DefaultRetryOn = []string{RetryOnConnectError, RetryOnTimeout, "502", "503", "504"}
+
+const (
+	PlainHTTPRedirect = "redirect"
+	PlainHTTPServe    = "serve"
+)
+
+const (
+	HostHeaderUpstream = "upstream"
+	HostHeaderPreserve = "preserve"
+	HostHeaderFixed    = "fixed"
+)
+
+func validRedirectStatus(statusCode int) bool {
+	switch statusCode {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+type LimitsConfig struct {
+	MaxURILength   int   `yaml:"max_uri_length"`
+	MaxHeaderCount int   `yaml:"max_header_count"`
+	MaxHeaderBytes int   `yaml:"max_header_bytes"`
+	MaxBodyBytes   int64 `yaml:"max_body_bytes"`
+}
+
+// TimeoutsConfig holds the connection timeouts in seconds.
+type TimeoutsConfig struct {
+	Handshake  int `yaml:"handshake"`
+	ReadHeader int `yaml:"read_header"`
+	ReadBody   int `yaml:"read_body"`
+	Write      int `yaml:"write"`
+	Idle       int `yaml:"idle"`
+}
+
+type UpstreamConfig struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+type BalancerConfig struct {
+	Policy  string `yaml:"policy"`
+	HashKey string `yaml:"hash_key"`
+}
+
+// HealthCheckConfig is the active health check of a route's upstreams, interval and timeout are in seconds.
+type HealthCheckConfig struct {
+	Path               string `yaml:"path"`
+	ExpectedStatus     int    `yaml:"expected_status"`
+	ExpectedBody       string `yaml:"expected_body"`
+	Interval           int    `yaml:"interval"`
+	Timeout            int    `yaml:"timeout"`
+	HealthyThreshold   int    `yaml:"healthy_threshold"`
+	UnhealthyThreshold int    `yaml:"unhealthy_threshold"`
+}
+
+// OutlierDetectionConfig ejects upstreams after consecutive failed requests, ejection times are in seconds.
+type OutlierDetectionConfig struct {
+	ConsecutiveFailures int `yaml:"consecutive_failures"`
+	BaseEjectionTime    int `yaml:"base_ejection_time"`
+	MaxEjectionTime     int `yaml:"max_ejection_time"`
+}
+
+type CircuitBreakerConfig struct {
+	MaxRequests int `yaml:"max_requests"`
+	MaxPending  int `yaml:"max_pending"`
+	MaxRetries  int `yaml:"max_retries"`
+}
+
+// RetriesConfig sends failed requests again, backoffs are in milliseconds.
+type RetriesConfig struct {
+	Attempts            int      `yaml:"attempts"`
+	RetryOn             []string `yaml:"retry_on"`
+	BackoffBase         int      `yaml:"backoff_base"`
+	BackoffMax          int      `yaml:"backoff_max"`
+	BudgetPercent       int      `yaml:"budget_percent"`
+	MinRetriesPerSecond int      `yaml:"min_retries_per_second"`
+	MaxBodyBytes        int64    `yaml:"max_body_bytes"`
+}
+
+// UpstreamTimeoutsConfig holds the timeouts of a route towards its upstreams in seconds.
+type UpstreamTimeoutsConfig struct {
+	Connect        int `yaml:"connect"`
+	TLSHandshake   int `yaml:"tls_handshake"`
+	ResponseHeader int `yaml:"response_header"`
+	BodyIdle       int `yaml:"body_idle"`
+	Total          int `yaml:"total"`
+}
+
+// ConnectionPoolConfig bounds the connections a route keeps to its upstreams, the idle timeout is in seconds.
+type ConnectionPoolConfig struct {
+	MaxIdle        int  `yaml:"max_idle"`
+	MaxIdlePerHost int  `yaml:"max_idle_per_host"`
+	MaxPerHost     int  `yaml:"max_per_host"`
+	IdleTimeout    int  `yaml:"idle_timeout"`
+	HTTP2          bool `yaml:"http2"`
+}
+
+// UpstreamTLSConfig verifies the certificates of a route's https upstreams, files are PEM encoded.
+type UpstreamTLSConfig struct {
+	CAFile             string   `yaml:"ca_file"`
+	CertFile           string   `yaml:"cert_file"`
+	KeyFile            string   `yaml:"key_file"`
+	ServerName         string   `yaml:"server_name"`
+	PinnedSPKI         []string `yaml:"pinned_spki"`
+	InsecureSkipVerify bool     `yaml:"insecure_skip_verify"`
+}
+
 type Route struct {
-	Path       string `yaml:"path"`
-	Host       string `yaml:"host"`
-	TargetPath string `yaml:"target_path"`
+	Path       string       `yaml:"path"`
+	Host       string       `yaml:"host"`
+	TargetPath string       `yaml:"target_path"`
+	Limits     LimitsConfig `yaml:"limits"`
+	Match      string       `yaml:"match"`
+	Hosts      []string     `yaml:"hosts"`
+
+	Upstreams   []UpstreamConfig  `yaml:"upstreams"`
+	Balancer    BalancerConfig    `yaml:"balancer"`
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+
+	OutlierDetection OutlierDetectionConfig `yaml:"outlier_detection"`
+	CircuitBreaker   CircuitBreakerConfig   `yaml:"circuit_breaker"`
+	Retries          RetriesConfig          `yaml:"retries"`
+	UpstreamTimeouts UpstreamTimeoutsConfig `yaml:"upstream_timeouts"`
+	ConnectionPool   ConnectionPoolConfig   `yaml:"connection_pool"`
+	UpstreamTLS      UpstreamTLSConfig      `yaml:"upstream_tls"`
+	DNSRefresh       int                    `yaml:"dns_refresh"`
+	HostHeader       string                 `yaml:"host_header"`
+	FixedHost        string                 `yaml:"fixed_host"`
+
+	ForwardExpectContinue bool     `yaml:"forward_expect_continue"`
+	PassThroughHeaders    []string `yaml:"pass_through_headers"`
+	AllowUpgrade          bool     `yaml:"allow_upgrade"`
+	UpgradeIdleTimeout    int      `yaml:"upgrade_idle_timeout"`
+	FlushInterval         int      `yaml:"flush_interval"`
+	PlainHTTP             string   `yaml:"plain_http"`
+	RedirectStatus        int      `yaml:"redirect_status"`
+}
+
+type PlainHTTPConfig struct {
+	Port              int    `yaml:"port"`
+	RedirectStatus    int    `yaml:"redirect_status"`
+	ACMEChallengeHost string `yaml:"acme_challenge_host"`
+	HealthPath        string `yaml:"health_path"`
 }
 
 type ServerConfig struct {
-	Port   int     `yaml:"port"`
-	Routes []Route `yaml:"routes"`
+	Port           int          `yaml:"port"`
+	Routes         []Route      `yaml:"routes"`
+	LenientParsing bool         `yaml:"lenient_parsing"`
+	Limits         LimitsConfig `yaml:"limits"`
+
+	MaxPipelinedRequests int `yaml:"max_pipelined_requests"`
+
+	ServerHeader string `yaml:"server_header"`
+	Via          string `yaml:"via"`
+
+	PlainHTTP PlainHTTPConfig `yaml:"plain_http"`
+	Timeouts  TimeoutsConfig  `yaml:"timeouts"`
 }
 
 type CachingConfig struct {
@@ -36,6 +252,345 @@ type Config struct {
 	Logger    LoggerConfig  `yaml:"logger"`
 }
 
+func (b BalancerConfig) toBalancerConfig() balancer.Config {
+	return balancer.Config{Policy: b.Policy, HashKey: b.HashKey}
+}
+
+// WithDefaults fills every unset limit from fallback.
+func (l LimitsConfig) WithDefaults(fallback LimitsConfig) LimitsConfig {
+	if l.MaxURILength == 0 {
+		l.MaxURILength = fallback.MaxURILength
+	}
+	if l.MaxHeaderCount == 0 {
+		l.MaxHeaderCount = fallback.MaxHeaderCount
+	}
+	if l.MaxHeaderBytes == 0 {
+		l.MaxHeaderBytes = fallback.MaxHeaderBytes
+	}
+	if l.MaxBodyBytes == 0 {
+		l.MaxBodyBytes = fallback.MaxBodyBytes
+	}
+	return l
+}
+
+func (l LimitsConfig) Validate() error {
+	if l.MaxURILength < 0 || l.MaxHeaderCount < 0 || l.MaxHeaderBytes < 0 || l.MaxBodyBytes < 0 {
+		return errors.New("limits can't be negative")
+	}
+	return nil
+}
+
+// WithDefaults fills every unset timeout from fallback.
+func (t TimeoutsConfig) WithDefaults(fallback TimeoutsConfig) TimeoutsConfig {
+	if t.Handshake == 0 {
+		t.Handshake = fallback.Handshake
+	}
+	if t.ReadHeader == 0 {
+		t.ReadHeader = fallback.ReadHeader
+	}
+	if t.ReadBody == 0 {
+		t.ReadBody = fallback.ReadBody
+	}
+	if t.Write == 0 {
+		t.Write = fallback.Write
+	}
+	if t.Idle == 0 {
+		t.Idle = fallback.Idle
+	}
+	return t
+}
+
+func (t TimeoutsConfig) Validate() error {
+	if t.Handshake < 0 || t.ReadHeader < 0 || t.ReadBody < 0 || t.Write < 0 || t.Idle < 0 {
+		return errors.New("timeouts can't be negative")
+	}
+	return nil
+}
+
+// WithDefaults fills every unset setting of an enabled health check with its default.
+func (h HealthCheckConfig) WithDefaults() HealthCheckConfig {
+	if h.Path == "" {
+		return h
+	}
+	if h.Interval == 0 {
+		h.Interval = DefaultHealthCheckInterval
+	}
+	if h.Timeout == 0 {
+		h.Timeout = DefaultHealthCheckTimeout
+	}
+	if h.HealthyThreshold == 0 {
+		h.HealthyThreshold = DefaultHealthyThreshold
+	}
+	if h.UnhealthyThreshold == 0 {
+		h.UnhealthyThreshold = DefaultUnhealthyThreshold
+	}
+	return h
+}
+
+func (h HealthCheckConfig) Validate() error {
+	if h.Path == "" {
+		if h != (HealthCheckConfig{}) {
+			return errors.New("health check path is not set")
+		}
+		return nil
+	}
+	if !strings.HasPrefix(h.Path, "/") {
+		return errors.New("health check path has to start with /")
+	}
+	if h.ExpectedStatus != 0 && (h.ExpectedStatus < 100 || h.ExpectedStatus > 599) {
+		return fmt.Errorf("%d is not a health check status", h.ExpectedStatus)
+	}
+	if h.Interval < 0 || h.Timeout < 0 || h.HealthyThreshold < 0 || h.UnhealthyThreshold < 0 {
+		return errors.New("health check interval, timeout and thresholds can't be negative")
+	}
+	return nil
+}
+
+func (h HealthCheckConfig) toHealthConfig() health.Config {
+	return health.Config{
+		Path:               h.Path,
+		ExpectedStatus:     h.ExpectedStatus,
+		ExpectedBody:       h.ExpectedBody,
+		Interval:           time.Duration(h.Interval) * time.Second,
+		Timeout:            time.Duration(h.Timeout) * time.Second,
+		HealthyThreshold:   h.HealthyThreshold,
+		UnhealthyThreshold: h.UnhealthyThreshold,
+	}
+}
+
+// WithDefaults fills the ejection times of an enabled outlier detection with their defaults.
+func (o OutlierDetectionConfig) WithDefaults() OutlierDetectionConfig {
+	if o.ConsecutiveFailures == 0 {
+		return o
+	}
+	if o.BaseEjectionTime == 0 {
+		o.BaseEjectionTime = DefaultBaseEjectionTime
+	}
+	if o.MaxEjectionTime == 0 {
+		o.MaxEjectionTime = max(DefaultMaxEjectionTime, o.BaseEjectionTime)
+	}
+	return o
+}
+
+func (o OutlierDetectionConfig) Validate() error {
+	if o.ConsecutiveFailures < 0 || o.BaseEjectionTime < 0 || o.MaxEjectionTime < 0 {
+		return errors.New("outlier detection settings can't be negative")
+	}
+	if o.MaxEjectionTime != 0 && o.MaxEjectionTime < o.BaseEjectionTime {
+		return errors.New("max ejection time can't be shorter than the base ejection time")
+	}
+	return nil
+}
+
+func (o OutlierDetectionConfig) toOutlierConfig() health.OutlierConfig {
+	return health.OutlierConfig{
+		ConsecutiveFailures: o.ConsecutiveFailures,
+		BaseEjectionTime:    time.Duration(o.BaseEjectionTime) * time.Second,
+		MaxEjectionTime:     time.Duration(o.MaxEjectionTime) * time.Second,
+	}
+}
+
+func (c CircuitBreakerConfig) Validate() error {
+	if c.MaxRequests < 0 || c.MaxPending < 0 || c.MaxRetries < 0 {
+		return errors.New("circuit breaker limits can't be negative")
+	}
+	return nil
+}
+
+func (c CircuitBreakerConfig) toBreakerConfig() breaker.Config {
+	return breaker.Config{MaxRequests: c.MaxRequests, MaxPending: c.MaxPending, MaxRetries: c.MaxRetries}
+}
+
+// WithDefaults fills every unset setting of enabled retries with its default.
+func (r RetriesConfig) WithDefaults() RetriesConfig {
+	if r.Attempts == 0 {
+		return r
+	}
+	if len(r.RetryOn) == 0 {
+		r.RetryOn = DefaultRetryOn
+	}
+	if r.BackoffBase == 0 {
+		r.BackoffBase = DefaultRetryBackoffBase
+	}
+	if r.BackoffMax == 0 {
+		r.BackoffMax = max(DefaultRetryBackoffMax, r.BackoffBase)
+	}
+	if r.BudgetPercent == 0 {
+		r.BudgetPercent = DefaultRetryBudgetPercent
+	}
+	if r.MinRetriesPerSecond == 0 {
+		r.MinRetriesPerSecond = DefaultMinRetriesPerSecond
+	}
+	if r.MaxBodyBytes == 0 {
+		r.MaxBodyBytes = DefaultRetryMaxBodyBytes
+	}
+	return r
+}
+
+func (r RetriesConfig) Validate() error {
+	if r.Attempts < 0 || r.BackoffBase < 0 || r.BackoffMax < 0 || r.MinRetriesPerSecond < 0 || r.MaxBodyBytes < 0 {
+		return errors.New("retry settings can't be negative")
+	}
+	if r.BackoffMax != 0 && r.BackoffMax < r.BackoffBase {
+		return errors.New("max retry backoff can't be shorter than the base backoff")
+	}
+	if r.BudgetPercent < 0 || r.BudgetPercent > 100 {
+		return errors.New("retry budget has to be a percentage")
+	}
+	for _, condition := range r.RetryOn {
+		if condition == RetryOnConnectError || condition == RetryOnTimeout {
+			continue
+		}
+		if statusCode, err := strconv.Atoi(condition); err != nil || statusCode < 400 || statusCode > 599 {
+			return fmt.Errorf("can't retry on %q, only on %q, %q and 4xx or 5xx statuses", condition, RetryOnConnectError, RetryOnTimeout)
+		}
+	}
+	return nil
+}
+
+func (r RetriesConfig) toRetryPolicy() *retry.Policy {
+	policy := &retry.Policy{
+		Attempts:     r.Attempts,
+		Statuses:     make(map[int]bool),
+		BackoffBase:  time.Duration(r.BackoffBase) * time.Millisecond,
+		BackoffMax:   time.Duration(r.BackoffMax) * time.Millisecond,
+		MaxBodyBytes: r.MaxBodyBytes,
+		Budget:       retry.NewBudget(r.BudgetPercent, r.MinRetriesPerSecond),
+	}
+	for _, condition := range r.RetryOn {
+		switch condition {
+		case RetryOnConnectError:
+			policy.ConnectErrors = true
+		case RetryOnTimeout:
+			policy.Timeouts = true
+		default:
+			// Checked when the config was loaded
+			statusCode, _ := strconv.Atoi(condition)
+			policy.Statuses[statusCode] = true
+		}
+	}
+	return policy
+}
+
+// WithDefaults fills every unset timeout but the total from fallback.
+func (t UpstreamTimeoutsConfig) WithDefaults(fallback UpstreamTimeoutsConfig) UpstreamTimeoutsConfig {
+	if t.Connect == 0 {
+		t.Connect = fallback.Connect
+	}
+	if t.TLSHandshake == 0 {
+		t.TLSHandshake = fallback.TLSHandshake
+	}
+	if t.ResponseHeader == 0 {
+		t.ResponseHeader = fallback.ResponseHeader
+	}
+	if t.BodyIdle == 0 {
+		t.BodyIdle = fallback.BodyIdle
+	}
+	return t
+}
+
+func (t UpstreamTimeoutsConfig) Validate() error {
+	if t.Connect < 0 || t.TLSHandshake < 0 || t.ResponseHeader < 0 || t.BodyIdle < 0 || t.Total < 0 {
+		return errors.New("upstream timeouts can't be negative")
+	}
+	return nil
+}
+
+// WithDefaults fills every unset limit from fallback.
+func (p ConnectionPoolConfig) WithDefaults(fallback ConnectionPoolConfig) ConnectionPoolConfig {
+	if p.MaxIdle == 0 {
+		p.MaxIdle = fallback.MaxIdle
+	}
+	if p.MaxIdlePerHost == 0 {
+		p.MaxIdlePerHost = fallback.MaxIdlePerHost
+	}
+	if p.IdleTimeout == 0 {
+		p.IdleTimeout = fallback.IdleTimeout
+	}
+	return p
+}
+
+func (p ConnectionPoolConfig) Validate() error {
+	if p.MaxIdle < 0 || p.MaxIdlePerHost < 0 || p.MaxPerHost < 0 || p.IdleTimeout < 0 {
+		return errors.New("connection pool limits can't be negative")
+	}
+	return nil
+}
+
+func (t UpstreamTLSConfig) Validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("upstream client certificate and key have to be set together")
+	}
+	_, err := t.toUpstreamTLS()
+	return err
+}
+
+// toUpstreamTLS loads the files of the config. Upstream certificates have to be
+// issued by the authorities of the CA file, or by the system's if there is none.
+func (t UpstreamTLSConfig) toUpstreamTLS() (structs.UpstreamTLS, error) {
+	upstreamTLS := structs.UpstreamTLS{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile == "" {
+		systemCAs, err := x509.SystemCertPool()
+		if err != nil {
+			return upstreamTLS, fmt.Errorf("can't load the system CA certificates: %w", err)
+		}
+		upstreamTLS.RootCAs = systemCAs
+	} else {
+		bundle, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return upstreamTLS, err
+		}
+		upstreamTLS.RootCAs = x509.NewCertPool()
+		if !upstreamTLS.RootCAs.AppendCertsFromPEM(bundle) {
+			return upstreamTLS, fmt.Errorf("%s contains no CA certificates", t.CAFile)
+		}
+	}
+
+	if t.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return upstreamTLS, fmt.Errorf("can't load the upstream client certificate: %w", err)
+		}
+		upstreamTLS.Certificates = []tls.Certificate{certificate}
+	}
+
+	for _, pin := range t.PinnedSPKI {
+		hash, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(hash) != sha256.Size {
+			return upstreamTLS, fmt.Errorf("pin %q is not a base64 encoded SHA-256 hash", pin)
+		}
+		upstreamTLS.PinnedSPKI = append(upstreamTLS.PinnedSPKI, [sha256.Size]byte(hash))
+	}
+
+	return upstreamTLS, nil
+}
+
+func toHostHeader(hostHeader string, fixedHost string) (structs.HostHeader, error) {
+	var mode structs.HostHeader
+	switch hostHeader {
+	case "", HostHeaderUpstream:
+		mode = structs.HostHeaderUpstream
+	case HostHeaderPreserve:
+		mode = structs.HostHeaderPreserve
+	case HostHeaderFixed:
+		if parsed, err := url.Parse("//" + fixedHost); fixedHost == "" || err != nil || parsed.Host != fixedHost {
+			return 0, fmt.Errorf("fixed host %q is not a host", fixedHost)
+		}
+		return structs.HostHeaderFixed, nil
+	default:
+		return 0, fmt.Errorf("host_header has to be %q, %q or %q", HostHeaderUpstream, HostHeaderPreserve, HostHeaderFixed)
+	}
+
+	if fixedHost != "" {
+		return 0, fmt.Errorf("fixed host is only sent with host_header %q", HostHeaderFixed)
+	}
+	return mode, nil
+}
+
 func (c *Config) Validate() error {
 	if c.Server.Port == 0 {
 		return errors.New("server port is not set")
@@ -50,9 +605,97 @@ func (c *Config) Validate() error {
 		if route.TargetPath == "" {
 			return errors.New("route target path is not set")
 		}
-		if route.Host == "" {
-			return errors.New("route host is not set")
+		if route.Host == "" && len(route.Upstreams) == 0 {
+			return fmt.Errorf("route %s: neither host nor upstreams are set", route.Path)
+		}
+		if route.Host != "" && len(route.Upstreams) > 0 {
+			return fmt.Errorf("route %s: host and upstreams can't be combined", route.Path)
+		}
+		for _, upstream := range route.Upstreams {
+			if upstream.URL == "" {
+				return fmt.Errorf("route %s: upstream url is not set", route.Path)
+			}
+			if upstream.Weight < 0 {
+				return fmt.Errorf("route %s: upstream weight can't be negative", route.Path)
+			}
 		}
+		if _, err := balancer.New(route.Balancer.toBalancerConfig()); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if err := route.HealthCheck.Validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if err := route.OutlierDetection.Validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if err := route.CircuitBreaker.Validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if err := route.Retries.Validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if err := route.UpstreamTimeouts.Validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if err := route.ConnectionPool.Validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if err := route.UpstreamTLS.Validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if err := route.Limits.Validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if _, err := toMatchType(route.Match); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if _, err := routePattern(route); err != nil {
+			return fmt.Errorf("route %s: invalid pattern: %w", route.Path, err)
+		}
+		for _, host := range route.Hosts {
+			if !validHostPattern(host) {
+				return fmt.Errorf("route %s: invalid host %q", route.Path, host)
+			}
+		}
+		if _, err := toHostHeader(route.HostHeader, route.FixedHost); err != nil {
+			return fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		if route.DNSRefresh < 0 {
+			return fmt.Errorf("route %s: DNS refresh interval can't be negative", route.Path)
+		}
+		if route.UpgradeIdleTimeout < 0 {
+			return fmt.Errorf("route %s: upgrade idle timeout can't be negative", route.Path)
+		}
+		if route.PlainHTTP != "" && route.PlainHTTP != PlainHTTPRedirect && route.PlainHTTP != PlainHTTPServe {
+			return fmt.Errorf("route %s: plain_http has to be %q or %q", route.Path, PlainHTTPRedirect, PlainHTTPServe)
+		}
+		if !validRedirectStatus(route.RedirectStatus) {
+			return fmt.Errorf("route %s: %d is not a redirect status", route.Path, route.RedirectStatus)
+		}
+	}
+	if err := checkDuplicateRoutes(c.Server.Routes); err != nil {
+		return err
+	}
+	if err := c.Server.Limits.Validate(); err != nil {
+		return err
+	}
+	if c.Server.PlainHTTP.Port < 0 || c.Server.PlainHTTP.Port > 65535 {
+		return errors.New("plain HTTP port is out of range")
+	}
+	if c.Server.PlainHTTP.Port != 0 && c.Server.PlainHTTP.Port == c.Server.Port {
+		return errors.New("plain HTTP and HTTPS can't share a port")
+	}
+	if !validRedirectStatus(c.Server.PlainHTTP.RedirectStatus) {
+		return fmt.Errorf("%d is not a redirect status", c.Server.PlainHTTP.RedirectStatus)
+	}
+	if healthPath := c.Server.PlainHTTP.HealthPath; healthPath != "" && (!strings.HasPrefix(healthPath, "/") || strings.HasPrefix(healthPath, handler.ACMEChallengePrefix)) {
+		return fmt.Errorf("plain HTTP health path %q has to be an absolute path outside of %s", healthPath, handler.ACMEChallengePrefix)
+	}
+	if err := c.Server.Timeouts.Validate(); err != nil {
+		return err
+	}
+	if c.Server.MaxPipelinedRequests < 0 {
+		return errors.New("max pipelined requests can't be negative")
 	}
 	if c.Logger.Level == "" {
 		return errors.New("logger level is not set")
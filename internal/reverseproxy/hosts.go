@@ -0,0 +1,68 @@
+package reverseproxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// normalizeHostPattern lower cases a host of a route and strips a trailing dot.
+func normalizeHostPattern(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+func hostPatterns(hosts []string) []string {
+	var patterns []string
+	for _, host := range hosts {
+		patterns = append(patterns, normalizeHostPattern(host))
+	}
+	return patterns
+}
+
+// validHostPattern accepts host names and wildcards in front of a domain,
+// like "*.example.com". Ports aren't part of a host pattern.
+func validHostPattern(host string) bool {
+	host = normalizeHostPattern(host)
+	host = strings.TrimPrefix(host, "*.")
+
+	return host != "" && !strings.ContainsAny(host, "*:/ ") && !strings.HasPrefix(host, ".")
+}
+
+// routeKey identifies a route within its host pattern.
+type routeKey struct {
+	host  string
+	match string
+	path  string
+}
+
+// checkDuplicateRoutes rejects the same path with the same match type twice on
+// the same host pattern or twice on the default server. Different patterns that
+// cover the same host, like "*.example.com" and "api.example.com", are allowed:
+// a request is only routed among the routes of its most specific pattern.
+func checkDuplicateRoutes(routes []Route) error {
+	seen := make(map[routeKey]bool)
+	for _, route := range routes {
+		match := route.Match
+		if match == "" {
+			match = MatchExact
+		}
+
+		// Routes without hosts belong to the default server
+		hosts := hostPatterns(route.Hosts)
+		if len(hosts) == 0 {
+			hosts = []string{""}
+		}
+
+		for _, host := range hosts {
+			key := routeKey{host: host, match: match, path: route.Path}
+			if seen[key] {
+				if host == "" {
+					return fmt.Errorf("route %s is defined twice for the default server", route.Path)
+				}
+				return fmt.Errorf("route %s is defined twice for host %s", route.Path, host)
+			}
+			seen[key] = true
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package reverseproxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"httpServer/internal/reverseproxy/structs"
+)
+
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchGlob   = "glob"
+	MatchRegex  = "regex"
+)
+
+func toMatchType(match string) (structs.MatchType, error) {
+	switch match {
+	case "", MatchExact:
+		return structs.MatchExact, nil
+	case MatchPrefix:
+		return structs.MatchPrefix, nil
+	case MatchGlob:
+		return structs.MatchGlob, nil
+	case MatchRegex:
+		return structs.MatchRegex, nil
+	}
+	return 0, fmt.Errorf("match has to be %q, %q, %q or %q", MatchExact, MatchPrefix, MatchGlob, MatchRegex)
+}
+
+// globToRegexp translates a glob into an anchored expression. Every * and **
+// becomes a capture group, so target paths can refer to them as $1, $2, ...
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		if glob[i] != '*' {
+			start := i
+			for i < len(glob) && glob[i] != '*' {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(glob[start:i]))
+			i--
+			continue
+		}
+
+		if i+1 < len(glob) && glob[i+1] == '*' {
+			expr.WriteString("(.*)")
+			i++
+		} else {
+			expr.WriteString("([^/]*)")
+		}
+	}
+
+	expr.WriteString("$")
+	return expr.String()
+}
+
+// routePattern compiles the path of glob and regex routes, other routes have none.
+func routePattern(route Route) (*regexp.Regexp, error) {
+	switch route.Match {
+	case MatchGlob:
+		return regexp.Compile(globToRegexp(route.Path))
+	case MatchRegex:
+		// The expression has to match the whole path
+		return regexp.Compile("^(?:" + route.Path + ")$")
+	}
+	return nil, nil
+}
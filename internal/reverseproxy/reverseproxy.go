@@ -2,10 +2,18 @@ package reverseproxy
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"httpServer/internal/balancer"
+	"httpServer/internal/breaker"
 	"httpServer/internal/cache"
 	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/health"
 	"httpServer/internal/logging"
+	http11 "httpServer/internal/request/http1.1"
+	"httpServer/internal/resolver"
+	"httpServer/internal/response"
+	"httpServer/internal/retry"
 	"log"
 	"net"
 	"net/http"
@@ -19,14 +27,78 @@ import (
 )
 
 type Proxy struct {
-	Port            uint16
-	Routes          []structs.ProxyRoute
-	AddedHeaders    http.Header
-	CachingActive   bool
-	CachingTTL      time.Duration
-	CachingChannels cache_structs.Channels
-	Blacklist       []net.IP
-	Logger          logging.Logger
+	Port                 uint16
+	Routes               []structs.ProxyRoute
+	AddedHeaders         http.Header
+	CachingActive        bool
+	CachingTTL           time.Duration
+	CachingChannels      cache_structs.Channels
+	Blacklist            []net.IP
+	Logger               logging.Logger
+	ParserOptions        http11.Options
+	Limits               structs.RequestLimits
+	MaxPipelinedRequests int
+	ResponseOptions      response.Options
+	PlainHTTP            structs.PlainHTTP
+	Timeouts             structs.Timeouts
+	// HealthChecks checks the upstreams of routes with a health check while the proxy runs.
+	HealthChecks *health.Checker
+}
+
+func toRequestLimits(limits LimitsConfig) structs.RequestLimits {
+	return structs.RequestLimits{
+		MaxURILength:   limits.MaxURILength,
+		MaxHeaderCount: limits.MaxHeaderCount,
+		MaxHeaderBytes: limits.MaxHeaderBytes,
+		MaxBodyBytes:   limits.MaxBodyBytes,
+	}
+}
+
+func toUpstreamTimeouts(timeouts UpstreamTimeoutsConfig) structs.UpstreamTimeouts {
+	return structs.UpstreamTimeouts{
+		Connect:        time.Duration(timeouts.Connect) * time.Second,
+		TLSHandshake:   time.Duration(timeouts.TLSHandshake) * time.Second,
+		ResponseHeader: time.Duration(timeouts.ResponseHeader) * time.Second,
+		BodyIdle:       time.Duration(timeouts.BodyIdle) * time.Second,
+		Total:          time.Duration(timeouts.Total) * time.Second,
+	}
+}
+
+func toConnectionPool(pool ConnectionPoolConfig) structs.ConnectionPool {
+	return structs.ConnectionPool{
+		MaxIdle:        pool.MaxIdle,
+		MaxIdlePerHost: pool.MaxIdlePerHost,
+		MaxPerHost:     pool.MaxPerHost,
+		IdleTimeout:    time.Duration(pool.IdleTimeout) * time.Second,
+		PreferHTTP2:    pool.HTTP2,
+	}
+}
+
+func toTimeouts(timeouts TimeoutsConfig) structs.Timeouts {
+	return structs.Timeouts{
+		Handshake:  time.Duration(timeouts.Handshake) * time.Second,
+		ReadHeader: time.Duration(timeouts.ReadHeader) * time.Second,
+		ReadBody:   time.Duration(timeouts.ReadBody) * time.Second,
+		Write:      time.Duration(timeouts.Write) * time.Second,
+		Idle:       time.Duration(timeouts.Idle) * time.Second,
+	}
+}
+
+// parseHost parses the URL of an upstream, its host name is looked up when connecting.
+func parseHost(host string) *url.URL {
+	parsedHost, err := url.Parse(host)
+	if err != nil {
+		log.Fatalf("Failed to parse host URL %s: %v", host, err)
+	}
+	return parsedHost
+}
+
+func passThroughHeaders(names []string) map[string]bool {
+	headers := make(map[string]bool, len(names))
+	for _, name := range names {
+		headers[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+	}
+	return headers
 }
 
 func NewReverseProxy(configPath string) *Proxy {
@@ -35,45 +107,143 @@ func NewReverseProxy(configPath string) *Proxy {
 		panic(err)
 	}
 
+	globalLimits := conf.Server.Limits.WithDefaults(LimitsConfig{
+		MaxURILength:   DefaultMaxURILength,
+		MaxHeaderCount: DefaultMaxHeaderCount,
+		MaxHeaderBytes: DefaultMaxHeaderBytes,
+	})
+
+	timeouts := conf.Server.Timeouts.WithDefaults(TimeoutsConfig{
+		Handshake:  DefaultHandshakeTimeout,
+		ReadHeader: DefaultReadHeaderTimeout,
+		ReadBody:   DefaultReadBodyTimeout,
+		Write:      DefaultWriteTimeout,
+		Idle:       DefaultIdleTimeout,
+	})
+
+	maxPipelinedRequests := conf.Server.MaxPipelinedRequests
+	if maxPipelinedRequests == 0 {
+		maxPipelinedRequests = DefaultMaxPipelinedRequests
+	}
+
+	logger, err := logging.NewDefaultLogger(logging.LogLevel(strings.ToUpper(conf.Logger.Level)), conf.Logger.File)
+	if err != nil {
+		panic(err)
+	}
+	if conf.Server.LenientParsing {
+		logger.Log(logging.LogLevelWarn, "Lenient HTTP/1.1 parsing is enabled, ambiguous requests will be forwarded")
+	}
+
+	healthChecks := health.NewChecker(logger)
+
 	var routes []structs.ProxyRoute
 	for _, route := range conf.Server.Routes {
-		parsedHost, err := url.Parse(route.Host)
-		if err != nil {
-			log.Fatalf("Failed to parse host URL %s: %v", route.Host, err)
+		var upstreams []*balancer.Upstream
+		if route.Host != "" {
+			upstreams = append(upstreams, balancer.NewUpstream(parseHost(route.Host), 1))
 		}
-
-		hostname := parsedHost.Hostname()
-		IPs, err := net.LookupIP(hostname)
-		if err != nil {
-			log.Fatalf("Failed to resolve IP for host %s: %v", hostname, err)
+		for _, upstream := range route.Upstreams {
+			upstreams = append(upstreams, balancer.NewUpstream(parseHost(upstream.URL), upstream.Weight))
 		}
 
-		if len(IPs) == 0 {
-			log.Fatalf("No IP addresses found for host %s", hostname)
+		var outliers *health.OutlierDetector
+		if outlierDetection := route.OutlierDetection.WithDefaults(); outlierDetection.ConsecutiveFailures > 0 {
+			outliers = health.NewOutlierDetector(route.Path, outlierDetection.toOutlierConfig(), logger)
 		}
 
-		ip := IPs[0]
-		resolvedHost := ip.String()
-		if ip.To4() == nil {
-			// This is an IPv6 address, enclose it in brackets
-			resolvedHost = fmt.Sprintf("[%s]", resolvedHost)
+		var retries *retry.Policy
+		if retriesConfig := route.Retries.WithDefaults(); retriesConfig.Attempts > 0 {
+			retries = retriesConfig.toRetryPolicy()
 		}
-		// Reconstruct the host URL with the resolved IP
-		resolvedURL := fmt.Sprintf("%s://%s", parsedHost.Scheme, resolvedHost)
-		if parsedHost.Port() != "" {
-			resolvedURL = fmt.Sprintf("%s:%s", resolvedURL, parsedHost.Port())
+
+		upgradeIdleTimeout := route.UpgradeIdleTimeout
+		if upgradeIdleTimeout == 0 {
+			upgradeIdleTimeout = DefaultUpgradeIdleTimeout
 		}
 
-		parsedURL, err := url.Parse(resolvedURL)
+		// These were checked when the config was loaded
+		match, _ := toMatchType(route.Match)
+		hostHeader, _ := toHostHeader(route.HostHeader, route.FixedHost)
+		pattern, _ := routePattern(route)
+		routeBalancer, _ := balancer.New(route.Balancer.toBalancerConfig())
+
+		upstreamTimeouts := route.UpstreamTimeouts.WithDefaults(UpstreamTimeoutsConfig{
+			Connect:        DefaultConnectTimeout,
+			TLSHandshake:   DefaultTLSHandshakeTimeout,
+			ResponseHeader: DefaultResponseHeaderTimeout,
+			BodyIdle:       DefaultBodyIdleTimeout,
+		})
+		upstreamTLS, err := route.UpstreamTLS.toUpstreamTLS()
 		if err != nil {
-			log.Fatalf("Failed to parse resolved host URL %s: %v", resolvedURL, err)
+			log.Fatalf("Failed to set up TLS to the upstreams of route %s: %v", route.Path, err)
+		}
+		if upstreamTLS.InsecureSkipVerify {
+			logger.Log(logging.LogLevelWarn, "Route %s doesn't verify the certificates of its upstreams, anyone on the network path can intercept its requests", route.Path)
+		}
+
+		dnsRefresh := route.DNSRefresh
+		if dnsRefresh == 0 {
+			dnsRefresh = DefaultDNSRefresh
 		}
 
-		routes = append(routes, structs.ProxyRoute{
+		connectionPool := route.ConnectionPool.WithDefaults(ConnectionPoolConfig{
+			MaxIdle:        DefaultMaxIdleConnections,
+			MaxIdlePerHost: DefaultMaxIdleConnectionsPerHost,
+			IdleTimeout:    DefaultIdleConnectionTimeout,
+		})
+
+		proxyRoute := structs.ProxyRoute{
 			Path:       route.Path,
-			Host:       parsedURL,
+			Host:       upstreams[0].URL,
+			Resolver:   resolver.New(route.Path, time.Duration(dnsRefresh)*time.Second, logger),
+			HostHeader: hostHeader,
+			FixedHost:  route.FixedHost,
+			Upstreams:  upstreams,
+			Balancer:   routeBalancer,
+			Outliers:   outliers,
+			Breaker:    breaker.New(route.CircuitBreaker.toBreakerConfig()),
+			Retries:    retries,
+			Timeouts:   toUpstreamTimeouts(upstreamTimeouts),
+			Pool:       toConnectionPool(connectionPool),
+			TLS:        upstreamTLS,
 			TargetPath: route.TargetPath,
-		})
+			Limits:     toRequestLimits(route.Limits.WithDefaults(globalLimits)),
+			Match:      match,
+			Pattern:    pattern,
+			Hosts:      hostPatterns(route.Hosts),
+
+			ForwardExpectContinue: route.ForwardExpectContinue,
+			PassThroughHeaders:    passThroughHeaders(route.PassThroughHeaders),
+			AllowUpgrade:          route.AllowUpgrade,
+			UpgradeIdleTimeout:    time.Duration(upgradeIdleTimeout) * time.Second,
+			FlushInterval:         time.Duration(route.FlushInterval) * time.Millisecond,
+			ServePlainHTTP:        route.PlainHTTP == PlainHTTPServe,
+			RedirectStatus:        route.RedirectStatus,
+		}
+		proxyRoute.Transport = handler.NewTransport(&proxyRoute)
+
+		if healthCheck := route.HealthCheck.WithDefaults(); healthCheck.Path != "" {
+			for _, upstream := range upstreams {
+				healthChecks.Add(route.Path, upstream, healthCheck.toHealthConfig(), proxyRoute.Transport)
+			}
+		}
+
+		routes = append(routes, proxyRoute)
+	}
+
+	plainHTTP := structs.PlainHTTP{
+		Port:           uint16(conf.Server.PlainHTTP.Port),
+		RedirectStatus: conf.Server.PlainHTTP.RedirectStatus,
+		HealthPath:     conf.Server.PlainHTTP.HealthPath,
+	}
+	if plainHTTP.RedirectStatus == 0 {
+		plainHTTP.RedirectStatus = DefaultRedirectStatus
+	}
+	if conf.Server.PlainHTTP.ACMEChallengeHost != "" {
+		plainHTTP.ACMEChallengeHost, err = url.Parse(conf.Server.PlainHTTP.ACMEChallengeHost)
+		if err != nil {
+			log.Fatalf("Failed to parse ACME challenge host URL %s: %v", conf.Server.PlainHTTP.ACMEChallengeHost, err)
+		}
 	}
 
 	// Parse blacklist IPs
@@ -82,11 +252,6 @@ func NewReverseProxy(configPath string) *Proxy {
 		blacklist = append(blacklist, net.ParseIP(ipStr))
 	}
 
-	logger, err := logging.NewDefaultLogger(logging.LogLevel(strings.ToUpper(conf.Logger.Level)), conf.Logger.File)
-	if err != nil {
-		panic(err)
-	}
-
 	return &Proxy{
 		Port:          uint16(conf.Server.Port),
 		Routes:        routes,
@@ -95,6 +260,21 @@ func NewReverseProxy(configPath string) *Proxy {
 		Blacklist:     blacklist,
 		Logger:        logger,
 		AddedHeaders:  conf.AddHeader,
+		ParserOptions: http11.Options{
+			Lenient:        conf.Server.LenientParsing,
+			MaxURILength:   globalLimits.MaxURILength,
+			MaxHeaderCount: globalLimits.MaxHeaderCount,
+			MaxHeaderBytes: globalLimits.MaxHeaderBytes,
+		},
+		Limits:               toRequestLimits(globalLimits),
+		MaxPipelinedRequests: maxPipelinedRequests,
+		ResponseOptions: response.Options{
+			Server: conf.Server.ServerHeader,
+			Via:    conf.Server.Via,
+		},
+		PlainHTTP:    plainHTTP,
+		Timeouts:     toTimeouts(timeouts),
+		HealthChecks: healthChecks,
 	}
 }
 
@@ -134,6 +314,38 @@ func (proxy *Proxy) GetCachingChannels() cache_structs.Channels {
 	return proxy.CachingChannels
 }
 
+func (proxy *Proxy) GetParserOptions() http11.Options {
+	return proxy.ParserOptions
+}
+
+func (proxy *Proxy) GetLimits() structs.RequestLimits {
+	return proxy.Limits
+}
+
+func (proxy *Proxy) GetMaxPipelinedRequests() int {
+	return proxy.MaxPipelinedRequests
+}
+
+func (proxy *Proxy) GetResponseOptions() response.Options {
+	return proxy.ResponseOptions
+}
+
+func (proxy *Proxy) GetPlainHTTP() structs.PlainHTTP {
+	return proxy.PlainHTTP
+}
+
+func (proxy *Proxy) GetTimeouts() structs.Timeouts {
+	return proxy.Timeouts
+}
+
+// UpstreamHealth returns the health of every upstream with a health check.
+func (proxy *Proxy) UpstreamHealth() []health.Status {
+	if proxy.HealthChecks == nil {
+		return nil
+	}
+	return proxy.HealthChecks.Status()
+}
+
 func (proxy *Proxy) closeIfBlacklisted(conn net.Conn) bool {
 	remoteIP, _, err := net.SplitHostPort(conn.RemoteAddr().String())
 	if err != nil {
@@ -183,8 +395,9 @@ func (proxy *Proxy) Start(cert []tls.Certificate) error {
 	r.NotFound(handler.NotFoundHandler)
 	r.MethodNotAllowed(handler.MethodNotAllowedHandler)
 
+	// Routes are resolved by the handler, which supports more than chi patterns
+	r.HandleFunc("/*", handler.ReverseProxyHandler)
 	for _, route := range proxy.Routes {
-		r.HandleFunc(route.Path, handler.ReverseProxyHandler)
 		proxy.Log(logging.LogLevelDebug, "Added route: %s", route.Path)
 	}
 
@@ -200,12 +413,64 @@ func (proxy *Proxy) Start(cert []tls.Certificate) error {
 		cache.InitCache(proxy, channels)
 	}
 
+	handler.InitHandler(proxy, channels)
+
+	proxy.HealthChecks.Start()
+	defer proxy.HealthChecks.Stop()
+
+	if proxy.PlainHTTP.Port != 0 {
+		plainListener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", proxy.PlainHTTP.Port))
+		if err != nil {
+			proxy.Log(logging.LogLevelError, "Failed to listen on port %d: %v", proxy.PlainHTTP.Port, err)
+			return err
+		}
+		defer func() {
+			if cerr := plainListener.Close(); cerr != nil {
+				proxy.Log(logging.LogLevelError, "Failed to close plain HTTP listener: %v", cerr)
+			}
+		}()
+
+		proxy.Log(logging.LogLevelInfo, "Listening on http://%s", plainListener.Addr().String())
+		go proxy.serve(plainListener, proxy.plainHTTPRouter())
+	}
+
 	proxy.Log(logging.LogLevelInfo, "Listening on https://%s", ln.Addr().String())
+	proxy.serve(tlsListener, r)
+	return nil
+}
 
-	handler.InitHandler(proxy, channels)
+// plainHTTPRouter redirects to HTTPS, except for routes served without TLS and ACME challenges.
+func (proxy *Proxy) plainHTTPRouter() chi.Router {
+	r := chi.NewRouter()
+	r.NotFound(handler.RedirectHandler)
+	r.MethodNotAllowed(handler.MethodNotAllowedHandler)
+
+	if proxy.PlainHTTP.ACMEChallengeHost != nil {
+		r.HandleFunc(handler.ACMEChallengePrefix+"*", handler.ACMEChallengeHandler)
+		proxy.Log(logging.LogLevelDebug, "Passing ACME challenges to %s", proxy.PlainHTTP.ACMEChallengeHost)
+	}
+	if proxy.PlainHTTP.HealthPath != "" {
+		r.Get(proxy.PlainHTTP.HealthPath, handler.UpstreamHealthHandler)
+		proxy.Log(logging.LogLevelDebug, "Serving upstream health on %s", proxy.PlainHTTP.HealthPath)
+	}
+
+	r.HandleFunc("/*", handler.PlainHTTPHandler)
+	for _, route := range proxy.Routes {
+		if route.ServePlainHTTP {
+			proxy.Log(logging.LogLevelDebug, "Serving route %s without TLS", route.Path)
+		}
+	}
+
+	return r
+}
+
+func (proxy *Proxy) serve(ln net.Listener, r chi.Router) {
 	for {
-		conn, err := tlsListener.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			proxy.Log(logging.LogLevelError, "Failed to accept connection: %v", err)
 			continue
 		}
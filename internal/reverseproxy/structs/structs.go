@@ -1,18 +1,162 @@
 package structs
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"httpServer/internal/balancer"
+	"httpServer/internal/breaker"
 	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/health"
 	"httpServer/internal/logging"
+	http11 "httpServer/internal/request/http1.1"
+	"httpServer/internal/resolver"
+	"httpServer/internal/response"
+	"httpServer/internal/retry"
 	"net"
 	"net/http"
 	"net/url"
+	"regexp"
 	"time"
 )
 
+// RequestLimits bounds the size of a request, zero disables a limit.
+type RequestLimits struct {
+	MaxURILength   int
+	MaxHeaderCount int
+	MaxHeaderBytes int
+	MaxBodyBytes   int64
+}
+
+// PlainHTTP configures the optional listener without TLS, a Port of 0 disables it.
+type PlainHTTP struct {
+	Port              uint16
+	RedirectStatus    int
+	ACMEChallengeHost *url.URL
+	// HealthPath serves the health of the upstreams as JSON, empty disables it.
+	HealthPath string
+}
+
+// Timeouts bound how long a client may take on a connection, zero disables a timeout.
+type Timeouts struct {
+	// Handshake covers the TLS handshake.
+	Handshake time.Duration
+	// ReadHeader covers the start-line and header section of a request, or the HTTP/2 connection preface.
+	ReadHeader time.Duration
+	// ReadBody covers the request body.
+	ReadBody time.Duration
+	// Write covers every single write to the client.
+	Write time.Duration
+	// Idle is how long a connection is kept open waiting for the next request.
+	Idle time.Duration
+}
+
+// MatchType selects how the Path of a route is compared to request paths.
+type MatchType int
+
+const (
+	// MatchExact only matches Path itself.
+	MatchExact MatchType = iota
+	// MatchPrefix matches Path and everything below it, the rest of the path is appended to TargetPath.
+	MatchPrefix
+	// MatchGlob matches Path with * standing for part of a segment and ** for any number of segments.
+	MatchGlob
+	// MatchRegex matches Path as a regular expression against the whole request path.
+	MatchRegex
+)
+
+// UpstreamTimeouts bound how long a route waits for its upstream, zero disables a timeout.
+type UpstreamTimeouts struct {
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	// ResponseHeader is how long the upstream may take to answer once the request was sent.
+	ResponseHeader time.Duration
+	// BodyIdle is the longest gap while the response body is read.
+	BodyIdle time.Duration
+	// Total covers the whole request including retries, and caps the timeout a client asks for.
+	Total time.Duration
+}
+
+// ConnectionPool bounds the connections a route keeps to its upstreams.
+type ConnectionPool struct {
+	MaxIdle        int
+	MaxIdlePerHost int
+	// MaxPerHost caps the connections to a single upstream, zero leaves them unbounded.
+	MaxPerHost  int
+	IdleTimeout time.Duration
+	// PreferHTTP2 talks HTTP/2 to upstreams that offer it during the TLS handshake.
+	PreferHTTP2 bool
+}
+
+// HostHeader selects the Host header a route sends to its upstreams.
+type HostHeader int
+
+const (
+	// HostHeaderUpstream sends the host of the upstream's URL.
+	HostHeaderUpstream HostHeader = iota
+	// HostHeaderPreserve passes on the Host of the client.
+	HostHeaderPreserve
+	// HostHeaderFixed sends the FixedHost of the route.
+	HostHeaderFixed
+)
+
+// UpstreamTLS verifies the certificates of a route's https upstreams.
+type UpstreamTLS struct {
+	// RootCAs are the authorities upstream certificates have to be issued by.
+	RootCAs *x509.CertPool
+	// Certificates are presented to upstreams that ask for a client certificate.
+	Certificates []tls.Certificate
+	// ServerName is sent as SNI and verified instead of the host name of the upstream.
+	ServerName string
+	// PinnedSPKI are SHA-256 hashes of public keys, if set one of them has to be in the certificate chain.
+	PinnedSPKI [][sha256.Size]byte
+	// InsecureSkipVerify accepts any certificate, pins are still checked.
+	InsecureSkipVerify bool
+}
+
 type ProxyRoute struct {
-	Path       string
-	Host       *url.URL
+	Path string
+	// Host is the upstream of routes without Upstreams.
+	Host *url.URL
+	// Resolver looks up the addresses of the upstreams' host names, nil if the route dials them as they are.
+	Resolver *resolver.Resolver
+	// HostHeader is the Host sent to upstreams, FixedHost if it is HostHeaderFixed.
+	HostHeader HostHeader
+	FixedHost  string
+	// Upstreams are the backends of the route, Balancer chooses between them.
+	Upstreams []*balancer.Upstream
+	Balancer  balancer.Balancer
+	// Outliers ejects upstreams whose requests keep failing, nil if the route doesn't.
+	Outliers *health.OutlierDetector
+	// Breaker caps the requests in flight on the route.
+	Breaker *breaker.Breaker
+	// Retries sends failed requests again, nil if the route doesn't.
+	Retries  *retry.Policy
+	Timeouts UpstreamTimeouts
+	Pool     ConnectionPool
+	TLS      UpstreamTLS
+	// Transport is shared by the requests of the route, so connections to its upstreams are reused.
+	Transport  *http.Transport
 	TargetPath string
+	Limits     RequestLimits
+	Match      MatchType
+	// Hosts are the lower case host names the route serves, "*.example.com" matches
+	// every subdomain. Routes without hosts serve all hosts no other route names.
+	Hosts []string
+	// Pattern is the compiled Path of glob and regex routes, TargetPath may refer to its capture groups.
+	Pattern *regexp.Regexp
+	// ForwardExpectContinue passes Expect: 100-continue on to the upstream instead of answering it locally.
+	ForwardExpectContinue bool
+	// PassThroughHeaders are hop-by-hop header fields (canonical names) forwarded anyway.
+	PassThroughHeaders map[string]bool
+	// AllowUpgrade tunnels protocol upgrades such as WebSockets, closing them after UpgradeIdleTimeout without traffic.
+	AllowUpgrade       bool
+	UpgradeIdleTimeout time.Duration
+	// FlushInterval is how often streamed responses are flushed, negative flushes after every write.
+	FlushInterval time.Duration
+	// ServePlainHTTP serves the route on the plain HTTP listener instead of redirecting to HTTPS.
+	ServePlainHTTP bool
+	RedirectStatus int
 }
 
 type ProxyHandler interface {
@@ -25,4 +169,11 @@ type ProxyHandler interface {
 	GetAddedHeaders() http.Header
 	GetCachingTTL() time.Duration
 	GetCachingChannels() cache_structs.Channels
+	GetParserOptions() http11.Options
+	GetLimits() RequestLimits
+	GetMaxPipelinedRequests() int
+	GetResponseOptions() response.Options
+	GetPlainHTTP() PlainHTTP
+	GetTimeouts() Timeouts
+	UpstreamHealth() []health.Status
 }
@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"httpServer/internal/balancer"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/reverseproxy"
+)
+
+func upstreams(weights ...int) []*balancer.Upstream {
+	var list []*balancer.Upstream
+	for i, weight := range weights {
+		list = append(list, balancer.NewUpstream(&url.URL{Scheme: "http", Host: fmt.Sprintf("10.0.0.%d:80", i+1)}, weight))
+	}
+	return list
+}
+
+func pickCounts(t *testing.T, b balancer.Balancer, list []*balancer.Upstream, picks int, request func(i int) *http.Request) map[*balancer.Upstream]int {
+	counts := make(map[*balancer.Upstream]int)
+	for i := 0; i < picks; i++ {
+		picked := b.Pick(list, request(i))
+		require.Contains(t, list, picked)
+		counts[picked]++
+	}
+	return counts
+}
+
+func anyRequest(int) *http.Request {
+	return httptest.NewRequest(http.MethodGet, "/", nil)
+}
+
+func TestRoundRobin(t *testing.T) {
+	b, err := balancer.New(balancer.Config{})
+	require.NoError(t, err)
+
+	list := upstreams(5, 1, 1)
+	for i := 0; i < 6; i++ {
+		assert.Same(t, list[i%3], b.Pick(list, anyRequest(i)))
+	}
+}
+
+func TestWeightedRoundRobinIsSmooth(t *testing.T) {
+	b, err := balancer.New(balancer.Config{Policy: balancer.WeightedRoundRobin})
+	require.NoError(t, err)
+
+	list := upstreams(5, 1, 1)
+	var order []int
+	for i := 0; i < 7; i++ {
+		picked := b.Pick(list, anyRequest(i))
+		for j, upstream := range list {
+			if upstream == picked {
+				order = append(order, j)
+			}
+		}
+	}
+
+	// The heavy upstream doesn't get all of its turns in a row
+	assert.Equal(t, []int{0, 0, 1, 0, 2, 0, 0}, order)
+}
+
+func TestLeastConnections(t *testing.T) {
+	b, err := balancer.New(balancer.Config{Policy: balancer.LeastConnections})
+	require.NoError(t, err)
+
+	list := upstreams(1, 1, 2)
+	list[0].Acquire()
+	list[1].Acquire()
+	list[1].Acquire()
+	list[2].Acquire()
+
+	// One request per weight of two is less load than one of weight one
+	assert.Same(t, list[2], b.Pick(list, anyRequest(0)))
+
+	list[0].Release()
+	assert.Same(t, list[0], b.Pick(list, anyRequest(0)))
+}
+
+func TestRandomTwoChoicesAvoidsBusyUpstream(t *testing.T) {
+	b, err := balancer.New(balancer.Config{Policy: balancer.RandomTwoChoices})
+	require.NoError(t, err)
+
+	list := upstreams(1, 1)
+	for i := 0; i < 10; i++ {
+		list[0].Acquire()
+	}
+
+	counts := pickCounts(t, b, list, 100, anyRequest)
+	assert.Equal(t, 100, counts[list[1]])
+}
+
+func TestConsistentHash(t *testing.T) {
+	list := upstreams(1, 1, 1, 1)
+
+	for _, test := range []struct {
+		hashKey string
+		request func(i int) *http.Request
+	}{
+		{"ip", func(i int) *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = fmt.Sprintf("192.0.2.%d:%d", i%10, 1000+i)
+			return req
+		}},
+		{"header:X-User", func(i int) *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-User", fmt.Sprintf("user-%d", i%10))
+			return req
+		}},
+		{"cookie:session", func(i int) *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: fmt.Sprintf("s%d", i%10)})
+			return req
+		}},
+	} {
+		b, err := balancer.New(balancer.Config{Policy: balancer.ConsistentHash, HashKey: test.hashKey})
+		require.NoError(t, err)
+
+		// Every key sticks to one upstream
+		assigned := make(map[int]*balancer.Upstream)
+		for i := 0; i < 100; i++ {
+			picked := b.Pick(list, test.request(i))
+			if previous, ok := assigned[i%10]; ok {
+				assert.Same(t, previous, picked, test.hashKey)
+			}
+			assigned[i%10] = picked
+		}
+
+		// Without one upstream only its keys move
+		for key, upstream := range assigned {
+			if upstream == list[3] {
+				continue
+			}
+			assert.Same(t, upstream, b.Pick(list[:3], test.request(key)), test.hashKey)
+		}
+	}
+
+	_, err := balancer.New(balancer.Config{Policy: balancer.ConsistentHash, HashKey: "query:id"})
+	assert.Error(t, err)
+}
+
+type firstUpstream struct{}
+
+func (firstUpstream) Pick(upstreams []*balancer.Upstream, _ *http.Request) *balancer.Upstream {
+	return upstreams[0]
+}
+
+func TestCustomBalancerAndUpstreamsConfig(t *testing.T) {
+	var hits [2]int
+	var servers []*httptest.Server
+	for i := range hits {
+		i := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i]++
+			_, _ = io.WriteString(w, "ok")
+		}))
+		defer server.Close()
+		servers = append(servers, server)
+	}
+
+	routes := func(policy string) string {
+		return fmt.Sprintf("    - path: /\n      target_path: /\n      balancer:\n        policy: %s\n      upstreams:\n        - url: %s\n          weight: 2\n        - url: %s\n", policy, servers[0].URL, servers[1].URL)
+	}
+
+	_, err := reverseproxy.LoadConfig(writeConfig(t, routes("first")))
+	assert.Error(t, err)
+
+	balancer.Register("first", func(balancer.Config) (balancer.Balancer, error) { return firstUpstream{}, nil })
+	t.Cleanup(func() { balancer.Unregister("first") })
+	handler.InitHandler(reverseproxy.NewReverseProxy(writeConfig(t, routes("first"))), cache_structs.Channels{})
+	for i := 0; i < 4; i++ {
+		recorder := httptest.NewRecorder()
+		handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+	assert.Equal(t, [2]int{4, 0}, hits)
+
+	handler.InitHandler(reverseproxy.NewReverseProxy(writeConfig(t, routes(balancer.RoundRobin))), cache_structs.Channels{})
+	for i := 0; i < 4; i++ {
+		recorder := httptest.NewRecorder()
+		handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+	assert.Equal(t, [2]int{6, 2}, hits)
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/reverseproxy"
+)
+
+func TestUpstreamConnectionsAreReused(t *testing.T) {
+	var opened, closed atomic.Int64
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			opened.Add(1)
+		case http.StateClosed:
+			closed.Add(1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	config := writeConfig(t, fmt.Sprintf("    - path: /\n      host: %s\n      target_path: /\n      connection_pool:\n        max_idle_per_host: 4\n", upstream.URL))
+	handler.InitHandler(reverseproxy.NewReverseProxy(config), cache_structs.Channels{})
+
+	for i := 0; i < 5; i++ {
+		recorder := httptest.NewRecorder()
+		handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+	assert.Equal(t, int64(1), opened.Load())
+
+	// A new configuration comes with new transports, the old connections are let go
+	handler.InitHandler(reverseproxy.NewReverseProxy(config), cache_structs.Channels{})
+	require.Eventually(t, func() bool { return closed.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, int64(2), opened.Load())
+}
+
+func TestUpstreamHTTP2Preference(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	for _, test := range []struct {
+		http2 bool
+		proto string
+	}{
+		{false, "HTTP/1.1"},
+		{true, "HTTP/2.0"},
+	} {
+		handler.InitHandler(reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf(
+			"    - path: /\n      host: %s\n      target_path: /\n      connection_pool:\n        http2: %t\n      upstream_tls:\n        ca_file: %s\n",
+			upstream.URL, test.http2, caFile(t, upstream)))), cache_structs.Channels{})
+
+		recorder := httptest.NewRecorder()
+		handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, test.proto, recorder.Body.String())
+	}
+}
+
+func TestConnectionPoolValidation(t *testing.T) {
+	_, err := reverseproxy.LoadConfig(writeConfig(t, "    - path: /\n      host: http://127.0.0.1\n      target_path: /\n      connection_pool:\n        max_per_host: -1\n"))
+	assert.Error(t, err)
+}
@@ -44,7 +44,7 @@ func TestDecoderHeaderLiterals(t *testing.T) {
 	}
 
 	headersPref := []*hpack.HeaderField{
-		{":path", "/sample/path", false},
+		{HeaderFieldName: ":path", HeaderFieldValue: "/sample/path"},
 	}
 
 	dec := hpack.NewDecoder()
@@ -53,5 +53,5 @@ func TestDecoderHeaderLiterals(t *testing.T) {
 	assert.NoError(t, err, "Error decoding headers after encoded payload")
 	assert.Len(t, headersAfter, 1)
 
-	assert.Equal(t, headersPref[0], headersAfter[0])
+	assert.Equal(t, *headersPref[0], headersAfter[0])
 }
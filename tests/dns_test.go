@@ -0,0 +1,264 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/resolver"
+	"httpServer/internal/reverseproxy"
+)
+
+// fakeDNS answers lookups with the addresses it was last given, or fails if there are none.
+type fakeDNS struct {
+	mutex     sync.Mutex
+	addresses []string
+	lookups   int
+}
+
+func (d *fakeDNS) set(addresses ...string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.addresses = addresses
+}
+
+func (d *fakeDNS) lookup(_ context.Context, host string) ([]net.IPAddr, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.lookups++
+
+	if len(d.addresses) == 0 {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+	}
+	var found []net.IPAddr
+	for _, address := range d.addresses {
+		found = append(found, net.IPAddr{IP: net.ParseIP(address)})
+	}
+	return found, nil
+}
+
+func TestResolverRefreshesAddresses(t *testing.T) {
+	dns := &fakeDNS{}
+	dns.set("10.0.0.2", "10.0.0.1")
+	r := resolver.New("/", 20*time.Millisecond, discardLogger{})
+	r.Lookup = dns.lookup
+	var changes atomic.Int64
+	r.OnChange(func() { changes.Add(1) })
+
+	// Every call starts at the next address
+	first, err := r.Addresses(context.Background(), "backend.test")
+	require.NoError(t, err)
+	second, err := r.Addresses(context.Background(), "backend.test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, first)
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.1"}, second)
+	assert.Equal(t, 1, dns.lookups)
+
+	dns.set("10.0.0.3")
+	time.Sleep(30 * time.Millisecond)
+	addresses, err := r.Addresses(context.Background(), "backend.test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.3"}, addresses)
+	assert.Equal(t, int64(1), changes.Load())
+
+	// Failed lookups keep the addresses from before
+	dns.set()
+	time.Sleep(30 * time.Millisecond)
+	addresses, err = r.Addresses(context.Background(), "backend.test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.3"}, addresses)
+	assert.Equal(t, 3, dns.lookups)
+
+	// A host that never resolved fails, addresses are used as they are
+	_, err = r.Addresses(context.Background(), "other.test")
+	assert.Error(t, err)
+	addresses, err = r.Addresses(context.Background(), "::1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"::1"}, addresses)
+}
+
+func TestResolverRefreshesInTheBackground(t *testing.T) {
+	dns := &fakeDNS{}
+	dns.set("10.0.0.1")
+	r := resolver.New("/", 20*time.Millisecond, discardLogger{})
+	r.Lookup = dns.lookup
+	changed := make(chan struct{}, 1)
+	r.OnChange(func() { changed <- struct{}{} })
+
+	_, err := r.Addresses(context.Background(), "backend.test")
+	require.NoError(t, err)
+	r.Start()
+
+	// Nobody asks for the addresses, the change is noticed anyway
+	dns.set("10.0.0.2")
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("change of the addresses wasn't noticed")
+	}
+
+	r.Stop()
+	dns.mutex.Lock()
+	lookups := dns.lookups
+	dns.mutex.Unlock()
+	time.Sleep(60 * time.Millisecond)
+	dns.mutex.Lock()
+	defer dns.mutex.Unlock()
+	assert.Equal(t, lookups, dns.lookups)
+}
+
+// listenOnBoth listens on the same port of 127.0.0.1 and 127.0.0.2.
+func listenOnBoth(t *testing.T) (net.Listener, net.Listener) {
+	for i := 0; i < 10; i++ {
+		first, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		second, err := net.Listen("tcp", fmt.Sprintf("127.0.0.2:%d", first.Addr().(*net.TCPAddr).Port))
+		if err == nil {
+			return first, second
+		}
+		_ = first.Close()
+	}
+	t.Skip("no port free on both 127.0.0.1 and 127.0.0.2")
+	return nil, nil
+}
+
+func TestRoutesFollowDNSChanges(t *testing.T) {
+	listeners := [2]net.Listener{}
+	listeners[0], listeners[1] = listenOnBoth(t)
+	for i, listener := range listeners {
+		i := i
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Every request on a new connection
+			w.Header().Set("Connection", "close")
+			_, _ = fmt.Fprintf(w, "%d %s", i, r.Host)
+		}))
+		server.Listener = listener
+		server.Start()
+		defer server.Close()
+	}
+	port := listeners[0].Addr().(*net.TCPAddr).Port
+
+	proxy := reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf("    - path: /\n      host: http://backend.test:%d\n      target_path: /\n", port)))
+	dns := &fakeDNS{}
+	dns.set("127.0.0.1", "127.0.0.2")
+	proxy.Routes[0].Resolver = resolver.New("/", 50*time.Millisecond, discardLogger{})
+	proxy.Routes[0].Resolver.Lookup = dns.lookup
+	proxy.Routes[0].Transport = handler.NewTransport(&proxy.Routes[0])
+	handler.InitHandler(proxy, cache_structs.Channels{})
+
+	served := func() string {
+		recorder := httptest.NewRecorder()
+		handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return recorder.Body.String()
+	}
+
+	// Connections alternate between the addresses, the upstream sees its host name
+	host := fmt.Sprintf("backend.test:%d", port)
+	assert.ElementsMatch(t, []string{"0 " + host, "1 " + host}, []string{served(), served()})
+
+	dns.set("127.0.0.2")
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "1 "+host, served())
+	}
+
+	// While DNS fails the last addresses are used
+	dns.set()
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, "1 "+host, served())
+}
+
+func TestPooledConnectionsFollowDNSChanges(t *testing.T) {
+	listeners := [2]net.Listener{}
+	listeners[0], listeners[1] = listenOnBoth(t)
+	for i, listener := range listeners {
+		i := i
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, "%d", i)
+		}))
+		server.Listener = listener
+		server.Start()
+		defer server.Close()
+	}
+
+	proxy := reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf("    - path: /\n      host: http://backend.test:%d\n      target_path: /\n", listeners[0].Addr().(*net.TCPAddr).Port)))
+	dns := &fakeDNS{}
+	dns.set("127.0.0.1")
+	proxy.Routes[0].Resolver = resolver.New("/", 20*time.Millisecond, discardLogger{})
+	proxy.Routes[0].Resolver.Lookup = dns.lookup
+	proxy.Routes[0].Transport = handler.NewTransport(&proxy.Routes[0])
+	handler.InitHandler(proxy, cache_structs.Channels{})
+
+	served := func() string {
+		recorder := httptest.NewRecorder()
+		handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return recorder.Body.String()
+	}
+
+	// The connection stays in the pool, nothing is dialed again
+	assert.Equal(t, "0", served())
+	dns.set("127.0.0.2")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "1", served())
+
+	// Replaced routes aren't looked up anymore
+	handler.InitHandler(reverseproxy.NewReverseProxy(writeConfig(t, "    - path: /\n      host: http://127.0.0.1\n      target_path: /\n")), cache_structs.Channels{})
+	dns.mutex.Lock()
+	lookups := dns.lookups
+	dns.mutex.Unlock()
+	time.Sleep(60 * time.Millisecond)
+	dns.mutex.Lock()
+	defer dns.mutex.Unlock()
+	assert.Equal(t, lookups, dns.lookups)
+}
+
+func TestHostHeader(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Host)
+	}))
+	defer upstream.Close()
+	upstreamHost := upstream.Listener.Addr().String()
+
+	for _, test := range []struct {
+		config string
+		host   string
+	}{
+		{"", upstreamHost},
+		{"      host_header: upstream\n", upstreamHost},
+		{"      host_header: preserve\n", "www.example.com"},
+		{"      host_header: fixed\n      fixed_host: api.internal:8080\n", "api.internal:8080"},
+	} {
+		handler.InitHandler(reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf(
+			"    - path: /\n      host: %s\n      target_path: /\n%s", upstream.URL, test.config))), cache_structs.Channels{})
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = "www.example.com"
+		handler.ReverseProxyHandler(recorder, req)
+		assert.Equal(t, test.host, recorder.Body.String(), test.config)
+	}
+}
+
+func TestHostHeaderAndDNSValidation(t *testing.T) {
+	for _, config := range []string{
+		"      host_header: client\n",
+		"      host_header: fixed\n",
+		"      host_header: fixed\n      fixed_host: api/internal\n",
+		"      host_header: preserve\n      fixed_host: api.internal\n",
+		"      dns_refresh: -1\n",
+	} {
+		_, err := reverseproxy.LoadConfig(writeConfig(t, "    - path: /\n      host: http://127.0.0.1\n      target_path: /\n"+config))
+		assert.Error(t, err, config)
+	}
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	http11 "httpServer/internal/request/http1.1"
+	http11Response "httpServer/internal/response/http1.1"
+)
+
+func TestExpectContinueDetection(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		continues   bool
+		unsupported bool
+	}{
+		{"no expectation", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\n\r\nx", false, false},
+		{"100-continue", "POST / HTTP/1.1\r\nHost: a\r\nExpect: 100-Continue\r\nContent-Length: 1\r\n\r\nx", true, false},
+		{"unknown expectation", "POST / HTTP/1.1\r\nHost: a\r\nExpect: teapot\r\nContent-Length: 1\r\n\r\nx", false, true},
+		{"HTTP/1.0 is ignored", "POST / HTTP/1.0\r\nHost: a\r\nExpect: 100-continue\r\nContent-Length: 1\r\n\r\nx", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err, _ := http11.Parser(rawReader(test.raw), http11.Options{})
+			require.NoError(t, err)
+			assert.Equal(t, test.continues, http11.ExpectsContinue(req))
+			assert.Equal(t, test.unsupported, http11.HasUnsupportedExpectation(req))
+		})
+	}
+}
+
+func TestHTTP11InterimResponse(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	received := make(chan []byte)
+	go func() {
+		var buffer bytes.Buffer
+		_, _ = buffer.ReadFrom(client)
+		received <- buffer.Bytes()
+	}()
+
+	w := http11Response.NewResponse(server)
+	w.Header().Set("Link", "</style.css>; rel=preload")
+	w.WriteHeader(http.StatusContinue)
+	w.WriteHeader(http.StatusEarlyHints)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+	require.NoError(t, w.Finish())
+	require.NoError(t, server.Close())
+
+	response := string(<-received)
+	assert.Regexp(t, "^HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\nHTTP/1.1 200 OK\r\n", response)
+}
@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/health"
+	"httpServer/internal/reverseproxy"
+)
+
+func TestHealthProbe(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			_, _ = io.WriteString(w, "status: ok")
+		case "/moved":
+			http.Redirect(w, r, "/healthz", http.StatusFound)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer upstream.Close()
+
+	base, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	client := &http.Client{
+		Timeout: 50 * time.Millisecond,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for _, test := range []struct {
+		config  health.Config
+		healthy bool
+	}{
+		{health.Config{Path: "/healthz"}, true},
+		{health.Config{Path: "/healthz", ExpectedStatus: http.StatusOK, ExpectedBody: "ok"}, true},
+		{health.Config{Path: "/healthz", ExpectedBody: "ready"}, false},
+		{health.Config{Path: "/broken"}, false},
+		// Redirects aren't followed, they are the answer of the upstream
+		{health.Config{Path: "/moved"}, true},
+		{health.Config{Path: "/moved", ExpectedStatus: http.StatusOK}, false},
+		{health.Config{Path: "/slow"}, false},
+	} {
+		err := health.Probe(context.Background(), client, base, test.config)
+		assert.Equal(t, test.healthy, err == nil, "%+v: %v", test.config, err)
+	}
+}
+
+func TestHealthChecksTakeUpstreamsOutOfRotation(t *testing.T) {
+	var healthy [2]atomic.Bool
+	var servers []*httptest.Server
+	for i := range healthy {
+		i := i
+		healthy[i].Store(true)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthz" && !healthy[i].Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = fmt.Fprint(w, i)
+		}))
+		defer server.Close()
+		servers = append(servers, server)
+	}
+
+	proxy := reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf(
+		"    - path: /\n      target_path: /\n      upstreams:\n        - url: %s\n        - url: %s\n      health_check:\n        path: /healthz\n",
+		servers[0].URL, servers[1].URL)))
+	handler.InitHandler(proxy, cache_structs.Channels{})
+
+	statuses := proxy.UpstreamHealth()
+	require.Len(t, statuses, 2)
+	for _, status := range statuses {
+		assert.True(t, status.Healthy)
+	}
+
+	// The configured interval is in seconds, check faster with the same upstreams
+	checker := health.NewChecker(proxy.Logger)
+	for _, upstream := range proxy.Routes[0].Upstreams {
+		checker.Add("/", upstream, health.Config{
+			Path:               "/healthz",
+			Interval:           10 * time.Millisecond,
+			Timeout:            time.Second,
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 2,
+		}, proxy.Routes[0].Transport)
+	}
+	checker.Start()
+	defer checker.Stop()
+
+	served := func() string {
+		recorder := httptest.NewRecorder()
+		handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return fmt.Sprintf("%d %s", recorder.Code, recorder.Body.String())
+	}
+	healthyCount := func() int {
+		count := 0
+		for _, status := range checker.Status() {
+			if status.Healthy {
+				count++
+			}
+		}
+		return count
+	}
+
+	healthy[0].Store(false)
+	require.Eventually(t, func() bool { return healthyCount() == 1 }, 2*time.Second, 5*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "200 1", served())
+	}
+	assert.Contains(t, checker.Status()[0].LastError, "503")
+
+	healthy[1].Store(false)
+	require.Eventually(t, func() bool { return healthyCount() == 0 }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, "503 ", served())
+
+	healthy[0].Store(true)
+	require.Eventually(t, func() bool { return healthyCount() == 1 }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, "200 0", served())
+	assert.Empty(t, checker.Status()[0].LastError)
+}
+
+func TestUpstreamHealthEndpoint(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	// The routes are followed by the plain HTTP listener of the server
+	proxy := reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf(
+		"    - path: /\n      target_path: /\n      upstreams:\n        - url: %s\n      health_check:\n        path: /healthz\n  plain_http:\n    port: 8080\n    health_path: /upstreams\n",
+		upstream.URL)))
+	handler.InitHandler(proxy, cache_structs.Channels{})
+	assert.Equal(t, "/upstreams", proxy.PlainHTTP.HealthPath)
+
+	recorder := httptest.NewRecorder()
+	handler.UpstreamHealthHandler(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/upstreams", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var statuses []health.Status
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &statuses))
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "/", statuses[0].Route)
+	assert.Equal(t, upstream.URL, statuses[0].URL)
+	assert.True(t, statuses[0].Healthy)
+
+	for _, healthPath := range []string{"upstreams", "/.well-known/acme-challenge/health"} {
+		_, err := reverseproxy.LoadConfig(writeConfig(t, "    - path: /\n      host: http://127.0.0.1\n      target_path: /\n  plain_http:\n    port: 8080\n    health_path: "+healthPath+"\n"))
+		assert.Error(t, err, healthPath)
+	}
+}
+
+func TestHealthCheckValidation(t *testing.T) {
+	for _, healthCheck := range []string{
+		"        expected_status: 200\n",
+		"        path: healthz\n",
+		"        path: /healthz\n        expected_status: 42\n",
+		"        path: /healthz\n        interval: -1\n",
+	} {
+		routes := "    - path: /\n      host: http://127.0.0.1\n      target_path: /\n      health_check:\n" + healthCheck
+		_, err := reverseproxy.LoadConfig(writeConfig(t, routes))
+		assert.Error(t, err, healthCheck)
+	}
+}
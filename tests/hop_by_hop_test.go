@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/reverseproxy"
+	"httpServer/internal/reverseproxy/structs"
+)
+
+// proxyTo points the handler at a single route forwarding path to upstream.
+func proxyTo(t *testing.T, upstream *httptest.Server, route structs.ProxyRoute) {
+	host, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	route.Host = host
+	if route.TargetPath == "" {
+		route.TargetPath = route.Path
+	}
+	handler.InitHandler(&reverseproxy.Proxy{Logger: discardLogger{}, Routes: []structs.ProxyRoute{route}}, cache_structs.Channels{})
+}
+
+func TestHopByHopHeadersAreStripped(t *testing.T) {
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Connection", "X-Upstream-Secret")
+		w.Header().Set("X-Upstream-Secret", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Visible", "1")
+		w.Header().Set("Trailer", "Checksum")
+		_, _ = w.Write([]byte("ok"))
+		w.Header().Set("Checksum", "abc")
+	}))
+	defer upstream.Close()
+
+	proxyTo(t, upstream, structs.ProxyRoute{Path: "/", PassThroughHeaders: map[string]bool{"X-Client-Kept": true}})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Connection", "X-Client-Secret, X-Client-Kept")
+	req.Header.Set("X-Client-Secret", "1")
+	req.Header.Set("X-Client-Kept", "1")
+	req.Header.Set("Proxy-Connection", "keep-alive")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("TE", "trailers, deflate")
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	recorder := httptest.NewRecorder()
+	handler.ReverseProxyHandler(recorder, req)
+
+	require.NotNil(t, received)
+	for _, name := range []string{"Connection", "X-Client-Secret", "Proxy-Connection", "Upgrade"} {
+		assert.Empty(t, received.Get(name), name)
+	}
+	assert.Equal(t, "1", received.Get("X-Client-Kept"))
+	assert.Equal(t, "trailers", received.Get("TE"))
+	assert.Equal(t, "https", received.Get("X-Forwarded-Proto"))
+
+	result := recorder.Result()
+	for _, name := range []string{"Connection", "X-Upstream-Secret", "Keep-Alive"} {
+		assert.Empty(t, result.Header.Get(name), name)
+	}
+	assert.Equal(t, "1", result.Header.Get("X-Visible"))
+	assert.Equal(t, "ok", strings.TrimSpace(recorder.Body.String()))
+	assert.Equal(t, "abc", result.Trailer.Get("Checksum"))
+}
@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	tested_hpack "github.com/tatsuhiro-t/go-http2-hpack"
+	"httpServer/internal/hpack"
+)
+
+// referenceDecode decodes one header block with the reference implementation.
+func referenceDecode(dec *tested_hpack.Decoder, block []byte) ([]hpack.HeaderField, error) {
+	headers := make([]hpack.HeaderField, 0)
+
+	for len(block) > 0 {
+		header, nRead, err := dec.Decode(block, true)
+		if err != nil {
+			return headers, err
+		}
+		block = block[nRead:]
+
+		if header == nil {
+			break
+		}
+		headers = append(headers, *hpack.NewHeaderField(header.Name, header.Value, header.NeverIndex))
+	}
+
+	return headers, nil
+}
+
+func sameHeaders(a []hpack.HeaderField, b []hpack.HeaderField, compareNeverIndexed bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].HeaderFieldName != b[i].HeaderFieldName || a[i].HeaderFieldValue != b[i].HeaderFieldValue {
+			return false
+		}
+		if compareNeverIndexed && a[i].NeverIndexed != b[i].NeverIndexed {
+			return false
+		}
+	}
+	return true
+}
+
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// headerListFromBytes turns fuzzer input into a header list. Names and values
+// alternate and are separated by NUL bytes.
+func headerListFromBytes(data []byte, neverIndex bool) []hpack.HeaderField {
+	fields := bytes.Split(data, []byte{0})
+
+	var headers []hpack.HeaderField
+	for i := 0; i+1 < len(fields); i += 2 {
+		headers = append(headers, *hpack.NewHeaderField(string(fields[i]), string(fields[i+1]), neverIndex && i%3 == 0))
+	}
+
+	return headers
+}
+
+func FuzzHPACKRoundTrip(f *testing.F) {
+	f.Add([]byte(":method\x00GET\x00:path\x00/\x00:authority\x00www.example.com"), true, false)
+	f.Add([]byte("custom-key\x00custom-value\x00cache-control\x00no-cache"), false, false)
+	f.Add([]byte("password\x00secret\x00set-cookie\x00foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600"), true, true)
+	f.Add([]byte("\x00\x00\xff\xfe\x00\x80\x01"), true, false)
+
+	f.Fuzz(func(t *testing.T, data []byte, useHuffman bool, neverIndex bool) {
+		headers := headerListFromBytes(data, neverIndex)
+
+		enc := hpack.NewEncoder()
+		enc.UseHuffman = useHuffman
+		dec := hpack.NewDecoder()
+		refDec := tested_hpack.NewDecoder()
+
+		// Encode the list twice, so the second block refers to the dynamic table
+		for block := 0; block < 2; block++ {
+			encoded := enc.Encode(headers)
+
+			decoded, err := dec.Decode(bufio.NewReader(bytes.NewReader(encoded)))
+			if err != nil {
+				t.Fatalf("block %d: decoding our own encoding failed: %v", block, err)
+			}
+			if !sameHeaders(headers, decoded, true) {
+				t.Fatalf("block %d: round trip mismatch:\n got %+v\nwant %+v", block, decoded, headers)
+			}
+
+			refDecoded, err := referenceDecode(refDec, encoded)
+			if err != nil {
+				t.Fatalf("block %d: reference decoder rejected our encoding: %v", block, err)
+			}
+			if !sameHeaders(headers, refDecoded, true) {
+				t.Fatalf("block %d: reference decoder mismatch:\n got %+v\nwant %+v", block, refDecoded, headers)
+			}
+		}
+
+		// And the other way around, the reference encoder against our decoder. Its
+		// Huffman encoder ranges over runes, so it only handles ASCII input.
+		if !isASCII(data) {
+			return
+		}
+		refEnc := tested_hpack.NewEncoder(tested_hpack.DEFAULT_HEADER_TABLE_SIZE)
+		dec = hpack.NewDecoder()
+		for block := 0; block < 2; block++ {
+			var refHeaders []*tested_hpack.Header
+			for _, header := range headers {
+				refHeaders = append(refHeaders, tested_hpack.NewHeader(header.HeaderFieldName, header.HeaderFieldValue, header.NeverIndexed))
+			}
+
+			var encoded bytes.Buffer
+			refEnc.Encode(&encoded, refHeaders)
+
+			decoded, err := dec.Decode(bufio.NewReader(bytes.NewReader(encoded.Bytes())))
+			if err != nil {
+				t.Fatalf("block %d: decoding the reference encoding failed: %v", block, err)
+			}
+			// The reference encoder drops the never indexed flag for indexable fields
+			if !sameHeaders(headers, decoded, false) {
+				t.Fatalf("block %d: reference encoding mismatch:\n got %+v\nwant %+v", block, decoded, headers)
+			}
+		}
+	})
+}
+
+// FuzzHPACKDecoder feeds arbitrary header blocks to both decoders. Whenever
+// both accept a block they have to agree on the decoded header list.
+func FuzzHPACKDecoder(f *testing.F) {
+	f.Add([]byte{0x82, 0x86, 0x84, 0x41, 0x0f, 'w', 'w', 'w', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm'})
+	f.Add([]byte{0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff})
+	f.Add([]byte{0x3f, 0xe1, 0x1f, 0x82})
+	f.Add([]byte{0x10, 0x08, 'p', 'a', 's', 's', 'w', 'o', 'r', 'd', 0x06, 's', 'e', 'c', 'r', 'e', 't'})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dec := hpack.NewDecoder()
+		decoded, err := dec.Decode(bufio.NewReader(bytes.NewReader(data)))
+
+		refDecoded, refErr := referenceDecode(tested_hpack.NewDecoder(), data)
+		if err != nil || refErr != nil {
+			return
+		}
+
+		if !sameHeaders(decoded, refDecoded, true) {
+			t.Fatalf("decoders disagree on %x:\n got %+v\nwant %+v", data, decoded, refDecoded)
+		}
+		if dec.DynamicTableSize() > dec.MaxDynamicTableSize {
+			t.Fatalf("dynamic table size %d exceeds %d", dec.DynamicTableSize(), dec.MaxDynamicTableSize)
+		}
+	})
+}
@@ -0,0 +1,251 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"httpServer/internal/hpack"
+)
+
+// Header block examples from RFC 7541 Appendix C. Every block of a sequence is
+// decoded with the same decoder, the dynamic table is checked after each one.
+
+type hpackBlock struct {
+	encoded      string
+	headers      []hpack.HeaderField
+	dynamicTable []hpack.HeaderField
+	tableSize    int
+}
+
+type hpackExample struct {
+	name         string
+	maxTableSize int
+	blocks       []hpackBlock
+}
+
+func hf(name string, value string) hpack.HeaderField {
+	return hpack.HeaderField{HeaderFieldName: name, HeaderFieldValue: value}
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	decoded, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	require.NoError(t, err)
+	return decoded
+}
+
+var (
+	requestBlock1 = []hpack.HeaderField{hf(":method", "GET"), hf(":scheme", "http"), hf(":path", "/"), hf(":authority", "www.example.com")}
+	requestTable1 = []hpack.HeaderField{hf(":authority", "www.example.com")}
+
+	requestBlock2 = []hpack.HeaderField{hf(":method", "GET"), hf(":scheme", "http"), hf(":path", "/"), hf(":authority", "www.example.com"), hf("cache-control", "no-cache")}
+	requestTable2 = []hpack.HeaderField{hf("cache-control", "no-cache"), hf(":authority", "www.example.com")}
+
+	requestBlock3 = []hpack.HeaderField{hf(":method", "GET"), hf(":scheme", "https"), hf(":path", "/index.html"), hf(":authority", "www.example.com"), hf("custom-key", "custom-value")}
+	requestTable3 = []hpack.HeaderField{hf("custom-key", "custom-value"), hf("cache-control", "no-cache"), hf(":authority", "www.example.com")}
+
+	responseBlock1 = []hpack.HeaderField{hf(":status", "302"), hf("cache-control", "private"), hf("date", "Mon, 21 Oct 2013 20:13:21 GMT"), hf("location", "https://www.example.com")}
+	responseTable1 = []hpack.HeaderField{hf("location", "https://www.example.com"), hf("date", "Mon, 21 Oct 2013 20:13:21 GMT"), hf("cache-control", "private"), hf(":status", "302")}
+
+	responseBlock2 = []hpack.HeaderField{hf(":status", "307"), hf("cache-control", "private"), hf("date", "Mon, 21 Oct 2013 20:13:21 GMT"), hf("location", "https://www.example.com")}
+	responseTable2 = []hpack.HeaderField{hf(":status", "307"), hf("location", "https://www.example.com"), hf("date", "Mon, 21 Oct 2013 20:13:21 GMT"), hf("cache-control", "private")}
+
+	responseBlock3 = []hpack.HeaderField{hf(":status", "200"), hf("cache-control", "private"), hf("date", "Mon, 21 Oct 2013 20:13:22 GMT"), hf("location", "https://www.example.com"), hf("content-encoding", "gzip"), hf("set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1")}
+	responseTable3 = []hpack.HeaderField{hf("set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"), hf("content-encoding", "gzip"), hf("date", "Mon, 21 Oct 2013 20:13:22 GMT")}
+)
+
+var rfc7541Examples = []hpackExample{
+	{
+		name: "C.2.1 literal header field with indexing",
+		blocks: []hpackBlock{{
+			encoded:      "400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572",
+			headers:      []hpack.HeaderField{hf("custom-key", "custom-header")},
+			dynamicTable: []hpack.HeaderField{hf("custom-key", "custom-header")},
+			tableSize:    55,
+		}},
+	},
+	{
+		name: "C.2.2 literal header field without indexing",
+		blocks: []hpackBlock{{
+			encoded: "040c 2f73 616d 706c 652f 7061 7468",
+			headers: []hpack.HeaderField{hf(":path", "/sample/path")},
+		}},
+	},
+	{
+		name: "C.2.3 literal header field never indexed",
+		blocks: []hpackBlock{{
+			encoded: "1008 7061 7373 776f 7264 0673 6563 7265 74",
+			headers: []hpack.HeaderField{{HeaderFieldName: "password", HeaderFieldValue: "secret", NeverIndexed: true}},
+		}},
+	},
+	{
+		name: "C.2.4 indexed header field",
+		blocks: []hpackBlock{{
+			encoded: "82",
+			headers: []hpack.HeaderField{hf(":method", "GET")},
+		}},
+	},
+	{
+		name: "C.3 requests without huffman coding",
+		blocks: []hpackBlock{
+			{
+				encoded:      "8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+				headers:      requestBlock1,
+				dynamicTable: requestTable1,
+				tableSize:    57,
+			},
+			{
+				encoded:      "8286 84be 5808 6e6f 2d63 6163 6865",
+				headers:      requestBlock2,
+				dynamicTable: requestTable2,
+				tableSize:    110,
+			},
+			{
+				encoded:      "8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65",
+				headers:      requestBlock3,
+				dynamicTable: requestTable3,
+				tableSize:    164,
+			},
+		},
+	},
+	{
+		name: "C.4 requests with huffman coding",
+		blocks: []hpackBlock{
+			{
+				encoded:      "8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+				headers:      requestBlock1,
+				dynamicTable: requestTable1,
+				tableSize:    57,
+			},
+			{
+				encoded:      "8286 84be 5886 a8eb 1064 9cbf",
+				headers:      requestBlock2,
+				dynamicTable: requestTable2,
+				tableSize:    110,
+			},
+			{
+				encoded:      "8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+				headers:      requestBlock3,
+				dynamicTable: requestTable3,
+				tableSize:    164,
+			},
+		},
+	},
+	{
+		name:         "C.5 responses without huffman coding",
+		maxTableSize: 256,
+		blocks: []hpackBlock{
+			{
+				encoded: "4803 3330 3258 0770 7269 7661 7465 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3120 474d " +
+					"546e 1768 7474 7073 3a2f 2f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+				headers:      responseBlock1,
+				dynamicTable: responseTable1,
+				tableSize:    222,
+			},
+			{
+				encoded:      "4803 3330 37c1 c0bf",
+				headers:      responseBlock2,
+				dynamicTable: responseTable2,
+				tableSize:    222,
+			},
+			{
+				encoded: "88c1 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3220 474d 54c0 5a04 677a 6970 7738 666f " +
+					"6f3d 4153 444a 4b48 514b 425a 584f 5157 454f 5049 5541 5851 5745 4f49 553b 206d 6178 2d61 6765 3d33 3630 303b " +
+					"2076 6572 7369 6f6e 3d31",
+				headers:      responseBlock3,
+				dynamicTable: responseTable3,
+				tableSize:    215,
+			},
+		},
+	},
+	{
+		name:         "C.6 responses with huffman coding",
+		maxTableSize: 256,
+		blocks: []hpackBlock{
+			{
+				encoded: "4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6 2d1b ff6e 919d 29ad 1718 63c7 " +
+					"8f0b 97c8 e9ae 82ae 43d3",
+				headers:      responseBlock1,
+				dynamicTable: responseTable1,
+				tableSize:    222,
+			},
+			{
+				encoded:      "4883 640e ffc1 c0bf",
+				headers:      responseBlock2,
+				dynamicTable: responseTable2,
+				tableSize:    222,
+			},
+			{
+				encoded: "88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab 77ad 94e7 821d d7f2 e6c7 b335 " +
+					"dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f 9587 3160 65c0 03ed 4ee5 b106 3d50 07",
+				headers:      responseBlock3,
+				dynamicTable: responseTable3,
+				tableSize:    215,
+			},
+		},
+	},
+}
+
+func TestDecoderRFC7541Examples(t *testing.T) {
+	for _, example := range rfc7541Examples {
+		t.Run(example.name, func(t *testing.T) {
+			dec := hpack.NewDecoder()
+			if example.maxTableSize != 0 {
+				dec.SetMaxDynamicTableSize(example.maxTableSize)
+			}
+
+			for i, block := range example.blocks {
+				headers, err := dec.Decode(bufio.NewReader(bytes.NewReader(decodeHex(t, block.encoded))))
+				require.NoError(t, err, "block %d", i+1)
+
+				assert.Equal(t, block.headers, headers, "headers of block %d", i+1)
+				assert.Equal(t, append([]hpack.HeaderField{}, block.dynamicTable...), dec.DynamicTable(), "dynamic table after block %d", i+1)
+				assert.Equal(t, block.tableSize, dec.DynamicTableSize(), "dynamic table size after block %d", i+1)
+			}
+		})
+	}
+}
+
+// The encoder indexes everything except never indexed fields, which is exactly
+// what the RFC examples do, so it has to reproduce them octet for octet.
+func TestEncoderRFC7541Examples(t *testing.T) {
+	for _, example := range rfc7541Examples {
+		t.Run(example.name, func(t *testing.T) {
+			if strings.HasPrefix(example.name, "C.2.2") {
+				t.Skip("the encoder never emits literals without indexing")
+			}
+
+			enc := hpack.NewEncoder()
+			if example.maxTableSize != 0 {
+				enc = hpack.NewEncoder(example.maxTableSize)
+			}
+			enc.UseHuffman = strings.Contains(example.name, "with huffman")
+
+			for i, block := range example.blocks {
+				encoded := enc.Encode(block.headers)
+				if i == 0 && example.maxTableSize != 0 {
+					// The RFC assumes the table size was already agreed on, drop our size update to 256
+					require.Equal(t, []byte{0x3f, 0xe1, 0x01}, encoded[:3])
+					encoded = encoded[3:]
+				}
+
+				assert.Equal(t, hex.EncodeToString(decodeHex(t, block.encoded)), hex.EncodeToString(encoded), "block %d", i+1)
+				assert.Equal(t, append([]hpack.HeaderField{}, block.dynamicTable...), enc.DynamicTable(), "dynamic table after block %d", i+1)
+			}
+		})
+	}
+}
+
+func TestHuffmanRejectsInvalidPadding(t *testing.T) {
+	// "www.example.com" followed by a full octet of EOS padding
+	_, err := hpack.HuffmanDecode(decodeHex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4ff ff"))
+	assert.ErrorIs(t, err, hpack.ErrInvalidHuffmanCode)
+
+	// Padding that is not a prefix of EOS
+	_, err = hpack.HuffmanDecode(decodeHex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4fe"))
+	assert.ErrorIs(t, err, hpack.ErrInvalidHuffmanCode)
+}
@@ -0,0 +1,204 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	http11 "httpServer/internal/request/http1.1"
+	"httpServer/internal/reverseproxy"
+)
+
+func rawReader(raw string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(raw))
+}
+
+func TestParserStreamsContentLengthBody(t *testing.T) {
+	reader := rawReader("POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 11\r\n\r\nhello world")
+
+	req, err, more := http11.Parser(reader, http11.Options{})
+	require.NoError(t, err)
+	assert.True(t, more)
+	assert.Equal(t, int64(11), req.ContentLength)
+
+	// Nothing of the body is consumed before the handler reads it
+	assert.Equal(t, 11, reader.Buffered())
+
+	content, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+}
+
+func TestParserStreamsChunkedBodyWithTrailer(t *testing.T) {
+	reader := rawReader("POST /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\nTrailer: Checksum\r\n\r\n" +
+		"5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nChecksum: abc\r\n\r\n")
+
+	req, err, _ := http11.Parser(reader, http11.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), req.ContentLength)
+	assert.Contains(t, req.Trailer, "Checksum")
+	assert.Empty(t, req.Trailer.Get("Checksum"))
+
+	content, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+	assert.Equal(t, "abc", req.Trailer.Get("Checksum"))
+}
+
+func TestParserTruncatedBody(t *testing.T) {
+	req, err, _ := http11.Parser(rawReader("POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 10\r\n\r\nshort"), http11.Options{})
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(req.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	req, err, _ = http11.Parser(rawReader("POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhelloX\r\n0\r\n\r\n"), http11.Options{})
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(req.Body)
+	assert.ErrorIs(t, err, http11.ErrMalformedChunk)
+}
+
+func TestDrainBodyBeforePipelinedRequest(t *testing.T) {
+	reader := rawReader("POST /first HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+		"POST /second HTTP/1.1\r\nHost: example.com\r\nContent-Length: 3\r\n\r\nxyz" +
+		"GET /third HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	for _, path := range []string{"/first", "/second", "/third"} {
+		req, err, _ := http11.Parser(reader, http11.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, path, req.URL.Path)
+
+		// The handler never touches the body
+		require.NoError(t, http11.DrainBody(req))
+	}
+}
+
+func TestStrictParserRejectsAmbiguousRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"content-length and transfer-encoding", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"},
+		{"duplicate content-length", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nabc"},
+		{"conflicting content-length list", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3, 4\r\n\r\nabcd"},
+		{"signed content-length", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +3\r\n\r\nabc"},
+		{"whitespace before colon", "GET / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding : chunked\r\n\r\n"},
+		{"obs-fold", "GET / HTTP/1.1\r\nHost: a\r\nX-Folded: one\r\n two\r\n\r\n"},
+		{"bare LF in start line", "GET / HTTP/1.1\nHost: a\r\n\r\n"},
+		{"bare LF in header", "GET / HTTP/1.1\r\nHost: a\n\r\n"},
+		{"invalid method", "G(T / HTTP/1.1\r\nHost: a\r\n\r\n"},
+		{"invalid header name", "GET / HTTP/1.1\r\nHost: a\r\nX@Y: z\r\n\r\n"},
+		{"multiple host headers", "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n"},
+		{"chunked twice", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, chunked\r\n\r\n0\r\n\r\n"},
+		{"unknown final coding", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip\r\n\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err, _ := http11.Parser(rawReader(test.raw), http11.Options{})
+			assert.ErrorIs(t, err, http11.ErrStrictViolation)
+
+			_, err, _ = http11.Parser(rawReader(test.raw), http11.Options{Lenient: true})
+			assert.NotErrorIs(t, err, http11.ErrStrictViolation)
+		})
+	}
+}
+
+func TestParserMatchesChunkedCaseInsensitively(t *testing.T) {
+	req, err, _ := http11.Parser(rawReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, Chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n"), http11.Options{})
+	require.NoError(t, err)
+
+	content, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(content))
+
+	_, err, _ = http11.Parser(rawReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: Chunked, gzip\r\n\r\n"), http11.Options{})
+	assert.ErrorIs(t, err, http11.ChunkEncodingError)
+}
+
+func TestStrictParserRejectsBareLFInChunks(t *testing.T) {
+	req, err, _ := http11.Parser(rawReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\nabc\r\n0\r\n\r\n"), http11.Options{})
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(req.Body)
+	assert.ErrorIs(t, err, http11.ErrStrictViolation)
+}
+
+func TestParserLimits(t *testing.T) {
+	options := http11.Options{MaxURILength: 16, MaxHeaderCount: 2, MaxHeaderBytes: 64}
+
+	_, err, _ := http11.Parser(rawReader("GET /"+strings.Repeat("a", 16)+" HTTP/1.1\r\nHost: a\r\n\r\n"), options)
+	assert.ErrorIs(t, err, http11.ErrURITooLong)
+
+	// The start-line is cut off long before it is read completely
+	_, err, _ = http11.Parser(rawReader("GET /"+strings.Repeat("a", 1<<20)), options)
+	assert.ErrorIs(t, err, http11.ErrURITooLong)
+
+	_, err, _ = http11.Parser(rawReader("GET / HTTP/1.1\r\nHost: a\r\nA: 1\r\nB: 2\r\n\r\n"), options)
+	assert.ErrorIs(t, err, http11.ErrHeaderTooLarge)
+
+	_, err, _ = http11.Parser(rawReader("GET / HTTP/1.1\r\nHost: a\r\nA: "+strings.Repeat("a", 1<<20)), options)
+	assert.ErrorIs(t, err, http11.ErrHeaderTooLarge)
+
+	req, err, _ := http11.Parser(rawReader("GET /"+strings.Repeat("a", 15)+" HTTP/1.1\r\nHost: a\r\nA: "+strings.Repeat("a", 40)+"\r\n\r\n"), options)
+	require.NoError(t, err)
+	assert.Len(t, req.Header.Get("A"), 40)
+}
+
+func TestRouteLimitsCountFieldLines(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	proxy := reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf(
+		"    - path: /\n      host: %s\n      target_path: /\n    - path: /strict\n      host: %s\n      target_path: /\n      limits:\n        max_header_count: 3\n",
+		upstream.URL, upstream.URL)))
+	handler.InitHandler(proxy, cache_structs.Channels{})
+
+	served := func(path string, fields string) int {
+		req, err, _ := http11.Parser(rawReader("GET "+path+" HTTP/1.1\r\nHost: a\r\n"+fields+"\r\n"), proxy.GetParserOptions())
+		require.NoError(t, err)
+		req.RemoteAddr = "192.0.2.1:1234"
+
+		recorder := httptest.NewRecorder()
+		handler.ReverseProxyHandler(recorder, req)
+		return recorder.Code
+	}
+
+	// One field line with many values is one field, however it is split up
+	etags := make([]string, 150)
+	for i := range etags {
+		etags[i] = fmt.Sprintf(`"%d"`, i)
+	}
+	assert.Equal(t, http.StatusOK, served("/", "If-None-Match: "+strings.Join(etags, ", ")+"\r\n"))
+	assert.Equal(t, http.StatusOK, served("/strict", "If-None-Match: "+strings.Join(etags, ", ")+"\r\nA: 1\r\n"))
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, served("/strict", "A: 1\r\nB: 2\r\nC: 3\r\n"))
+}
+
+func TestMalformedChunkIsTheClientsFault(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	proxy := reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf("    - path: /\n      host: %s\n      target_path: /\n", upstream.URL)))
+	handler.InitHandler(proxy, cache_structs.Channels{})
+
+	// The chunk size is broken after the first chunk went out already
+	req, err, _ := http11.Parser(rawReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\nZZ\r\nxyz\r\n0\r\n\r\n"), proxy.GetParserOptions())
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	recorder := httptest.NewRecorder()
+	handler.ReverseProxyHandler(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "close", recorder.Header().Get("Connection"))
+}
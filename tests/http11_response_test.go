@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	http11Response "httpServer/internal/response/http1.1"
+)
+
+// writeResponse runs handle against a response for req and returns the raw bytes sent.
+func writeResponse(t *testing.T, req *http.Request, handle func(w *http11Response.Response)) []byte {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	received := make(chan []byte)
+	go func() {
+		var buffer bytes.Buffer
+		_, _ = buffer.ReadFrom(client)
+		received <- buffer.Bytes()
+	}()
+
+	w := http11Response.NewResponse(server)
+	w.SetRequest(req)
+	handle(w)
+	require.NoError(t, w.Finish())
+	require.NoError(t, server.Close())
+
+	return <-received
+}
+
+func readResponse(t *testing.T, raw []byte, req *http.Request) (*http.Response, string) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), req)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(body)
+}
+
+func TestResponseSmallBodyGetsContentLength(t *testing.T) {
+	req := httpRequest(t, "HTTP/1.1")
+	raw := writeResponse(t, req, func(w *http11Response.Response) {
+		_, _ = w.Write([]byte("hello "))
+		_, _ = w.Write([]byte("world"))
+	})
+
+	resp, body := readResponse(t, raw, req)
+	assert.Equal(t, int64(11), resp.ContentLength)
+	assert.Empty(t, resp.TransferEncoding)
+	assert.Equal(t, "hello world", body)
+}
+
+func TestResponseLargeBodyIsChunked(t *testing.T) {
+	req := httpRequest(t, "HTTP/1.1")
+	part := strings.Repeat("x", http11Response.CONTENTSIZEMIN)
+	raw := writeResponse(t, req, func(w *http11Response.Response) {
+		w.Header().Set("Trailer", "Checksum")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(part))
+		_, _ = w.Write([]byte(part))
+		w.Header().Set("Checksum", "abc")
+	})
+
+	resp, body := readResponse(t, raw, req)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, part+part, body)
+	assert.Equal(t, "abc", resp.Trailer.Get("Checksum"))
+	assert.True(t, bytes.HasSuffix(raw, []byte("0\r\nChecksum: abc\r\n\r\n")))
+}
+
+func TestResponseToHTTP10ClosesConnection(t *testing.T) {
+	req := httpRequest(t, "HTTP/1.0")
+	part := strings.Repeat("x", 2*http11Response.CONTENTSIZEMIN)
+	raw := writeResponse(t, req, func(w *http11Response.Response) {
+		_, _ = w.Write([]byte(part))
+	})
+
+	resp, body := readResponse(t, raw, req)
+	assert.Empty(t, resp.TransferEncoding)
+	assert.True(t, resp.Close)
+	assert.Equal(t, part, body)
+}
+
+func TestResponseWithoutContent(t *testing.T) {
+	req := httpRequest(t, "HTTP/1.1")
+	raw := writeResponse(t, req, func(w *http11Response.Response) {
+		w.WriteHeader(http.StatusNoContent)
+		_, err := w.Write([]byte("ignored"))
+		assert.ErrorIs(t, err, http.ErrBodyNotAllowed)
+	})
+
+	resp, body := readResponse(t, raw, req)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Length"))
+	assert.Empty(t, body)
+	assert.True(t, bytes.HasSuffix(raw, []byte("\r\n\r\n")))
+}
+
+func httpRequest(t *testing.T, proto string) *http.Request {
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader("GET / " + proto + "\r\nHost: a\r\n\r\n")))
+	require.NoError(t, err)
+	return req
+}
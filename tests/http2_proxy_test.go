@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/reverseproxy"
+)
+
+// serveHTTP2 serves the routes of config over TLS like the proxy does and
+// returns its address and a client that only speaks HTTP/2.
+func serveHTTP2(t *testing.T, config string) (string, *http.Client) {
+	handler.InitHandler(reverseproxy.NewReverseProxy(config), cache_structs.Channels{})
+
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certificates := certServer.TLS.Certificates
+	certServer.Close()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certificates, NextProtos: []string{"h2"}})
+	require.NoError(t, err)
+
+	// Connections end with the test, the next one initialises the handler again
+	var served sync.WaitGroup
+	var mutex sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		_ = ln.Close()
+		mutex.Lock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+		mutex.Unlock()
+		served.Wait()
+	})
+
+	router := chi.NewRouter()
+	router.HandleFunc("/*", handler.ReverseProxyHandler)
+	served.Add(1)
+	go func() {
+		defer served.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			conns = append(conns, conn)
+			mutex.Unlock()
+
+			served.Add(1)
+			go func() {
+				defer served.Done()
+				handler.HandleAccept(conn, router)
+			}()
+		}
+	}()
+
+	transport := &http.Transport{
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}},
+		ForceAttemptHTTP2:     true,
+		ExpectContinueTimeout: 5 * time.Second,
+	}
+	t.Cleanup(transport.CloseIdleConnections)
+	return "https://" + ln.Addr().String(), &http.Client{Transport: transport, Timeout: 5 * time.Second}
+}
+
+func TestHTTP2RequestsAreProxied(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Seen-For", r.Header.Get("X-Forwarded-For"))
+		_, _ = fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.RequestURI(), body)
+	}))
+	defer upstream.Close()
+
+	address, client := serveHTTP2(t, writeConfig(t, fmt.Sprintf("    - path: /api\n      match: prefix\n      host: %s\n      target_path: /v1\n", upstream.URL)))
+
+	for _, test := range []struct {
+		method   string
+		body     string
+		upstream string
+	}{
+		{http.MethodGet, "", "GET /v1/users?page=2 "},
+		{http.MethodPost, "name=a", "POST /v1/users?page=2 name=a"},
+	} {
+		req, err := http.NewRequest(test.method, address+"/api/users?page=2", strings.NewReader(test.body))
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, resp.ProtoMajor)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, test.method)
+		assert.Equal(t, test.upstream, string(body))
+		assert.Equal(t, "127.0.0.1", resp.Header.Get("X-Seen-For"))
+	}
+}
+
+func TestHTTP2ExpectationIsDecidedByTheRoute(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	address, client := serveHTTP2(t, writeConfig(t, fmt.Sprintf(
+		"    - path: /\n      hosts: [api.example.com]\n      host: %s\n      target_path: /\n      limits:\n        max_body_bytes: 4\n", upstream.URL)))
+
+	for _, test := range []struct {
+		host   string
+		body   string
+		status int
+	}{
+		// No route serves the host, the catch-all of the router doesn't count
+		{"www.example.com", "tiny", http.StatusNotFound},
+		// The limit of the route is tighter than the global one
+		{"api.example.com", "too large", http.StatusRequestEntityTooLarge},
+	} {
+		var continued bool
+		trace := &httptrace.ClientTrace{Got100Continue: func() { continued = true }}
+		req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodPost, address+"/", strings.NewReader(test.body))
+		require.NoError(t, err)
+		req.Host = test.host
+		req.Header.Set("Expect", "100-continue")
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, 2, resp.ProtoMajor)
+		assert.Equal(t, test.status, resp.StatusCode, "%+v", test)
+		// Rejected before the body is sent
+		assert.False(t, continued, "%+v", test)
+	}
+}
+
+func TestRequestWithoutRemoteAddressFails(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	handler.InitHandler(reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf("    - path: /\n      host: %s\n      target_path: /\n", upstream.URL))), cache_structs.Channels{})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = ""
+	handler.ReverseProxyHandler(recorder, req)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+}
@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"httpServer/internal/balancer"
+	"httpServer/internal/breaker"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/health"
+	"httpServer/internal/reverseproxy"
+)
+
+func TestOutlierEjectionGrowsExponentially(t *testing.T) {
+	upstream := balancer.NewUpstream(&url.URL{Scheme: "http", Host: "10.0.0.1:80"}, 1)
+	detector := health.NewOutlierDetector("/", health.OutlierConfig{
+		ConsecutiveFailures: 3,
+		BaseEjectionTime:    time.Minute,
+		MaxEjectionTime:     3 * time.Minute,
+	}, discardLogger{})
+
+	// Failures have to come in a row
+	detector.Report(upstream, true)
+	detector.Report(upstream, true)
+	detector.Report(upstream, false)
+	detector.Report(upstream, true)
+	detector.Report(upstream, true)
+	assert.True(t, upstream.Available())
+
+	for _, ejection := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		for i := 0; i < 3; i++ {
+			detector.Report(upstream, true)
+		}
+		assert.False(t, upstream.Available())
+		assert.WithinDuration(t, time.Now().Add(ejection), upstream.EjectedUntil(), time.Second)
+
+		// Failures of requests sent before the ejection don't extend it
+		until := upstream.EjectedUntil()
+		for i := 0; i < 3; i++ {
+			detector.Report(upstream, true)
+		}
+		assert.Equal(t, until, upstream.EjectedUntil())
+
+		// End the ejection early instead of waiting for it
+		upstream.Eject(time.Now())
+		assert.True(t, upstream.Available())
+	}
+}
+
+func TestOutlierDetectionOnRoute(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "healthy")
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	proxy := reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf(
+		"    - path: /\n      target_path: /\n      upstreams:\n        - url: %s\n        - url: %s\n        - url: %s\n      outlier_detection:\n        consecutive_failures: 2\n",
+		healthy.URL, failing.URL, unreachable.URL)))
+	handler.InitHandler(proxy, cache_structs.Channels{})
+
+	codes := make(map[int]int)
+	for i := 0; i < 12; i++ {
+		recorder := httptest.NewRecorder()
+		handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		codes[recorder.Code]++
+	}
+
+	// Round robin hands each bad upstream two requests before it is ejected
+	assert.Equal(t, map[int]int{http.StatusOK: 8, http.StatusInternalServerError: 2, http.StatusBadGateway: 2}, codes)
+	upstreams := proxy.Routes[0].Upstreams
+	assert.True(t, upstreams[0].Available())
+	assert.False(t, upstreams[1].Available())
+	assert.False(t, upstreams[2].Available())
+}
+
+func TestClientDeadlinesDontEjectUpstreams(t *testing.T) {
+	var attempts atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	proxy := reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf(
+		"    - path: /\n      target_path: /\n      upstreams:\n        - url: %s\n      outlier_detection:\n        consecutive_failures: 2\n      retries:\n        attempts: 2\n        retry_on: [timeout]\n",
+		upstream.URL)))
+	handler.InitHandler(proxy, cache_structs.Channels{})
+
+	for _, hint := range [][2]string{{"grpc-timeout", "1m"}, {"Request-Timeout", "0.001"}} {
+		for i := 0; i < 3; i++ {
+			recorder, _ := proxiedWithHeader(hint[0], hint[1])
+			assert.Equal(t, http.StatusGatewayTimeout, recorder.Code, hint[0])
+		}
+	}
+	// Clients going away don't count either
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		handler.ReverseProxyHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+		cancel()
+	}
+
+	// Nothing was retried and the upstream stays in rotation
+	assert.LessOrEqual(t, attempts.Load(), int64(9))
+	assert.True(t, proxy.Routes[0].Upstreams[0].Available())
+	recorder := httptest.NewRecorder()
+	handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := breaker.New(breaker.Config{MaxRequests: 1, MaxPending: 1, MaxRetries: 1})
+
+	release, err := b.Acquire(context.Background())
+	require.NoError(t, err)
+
+	acquired := make(chan func())
+	go func() {
+		release, err := b.Acquire(context.Background())
+		assert.NoError(t, err)
+		acquired <- release
+	}()
+	require.Eventually(t, func() bool { return b.Pending() == 1 }, time.Second, time.Millisecond)
+
+	// Nobody else may wait
+	_, err = b.Acquire(context.Background())
+	assert.ErrorIs(t, err, breaker.ErrOpen)
+
+	release()
+	(<-acquired)()
+	assert.Equal(t, 0, b.Active())
+
+	release, err = b.Acquire(context.Background())
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = b.Acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	release()
+
+	assert.True(t, b.AcquireRetry())
+	assert.False(t, b.AcquireRetry())
+	b.ReleaseRetry()
+	assert.True(t, b.AcquireRetry())
+
+	// Without limits nothing is held back
+	var unlimited *breaker.Breaker
+	_, err = unlimited.Acquire(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, unlimited.AcquireRetry())
+}
+
+func TestCircuitBreakerOnRoute(t *testing.T) {
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-unblock
+	}))
+	defer upstream.Close()
+
+	handler.InitHandler(reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf(
+		"    - path: /\n      host: %s\n      target_path: /\n      circuit_breaker:\n        max_requests: 1\n", upstream.URL))), cache_structs.Channels{})
+
+	done := make(chan int)
+	go func() {
+		recorder := httptest.NewRecorder()
+		handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		done <- recorder.Code
+	}()
+	<-entered
+
+	recorder := httptest.NewRecorder()
+	handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	close(unblock)
+	assert.Equal(t, http.StatusOK, <-done)
+}
+
+func TestOutlierAndBreakerValidation(t *testing.T) {
+	for _, settings := range []string{
+		"      outlier_detection:\n        consecutive_failures: -1\n",
+		"      outlier_detection:\n        consecutive_failures: 5\n        base_ejection_time: 60\n        max_ejection_time: 30\n",
+		"      circuit_breaker:\n        max_pending: -1\n",
+	} {
+		_, err := reverseproxy.LoadConfig(writeConfig(t, "    - path: /\n      host: http://127.0.0.1\n      target_path: /\n"+settings))
+		assert.Error(t, err, settings)
+	}
+}
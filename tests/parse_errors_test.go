@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	http11 "httpServer/internal/request/http1.1"
+	"httpServer/internal/reverseproxy"
+)
+
+func TestParserErrorsCarryStatus(t *testing.T) {
+	options := http11.Options{MaxURILength: 16, MaxHeaderBytes: 64}
+
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+	}{
+		{"malformed start-line", "GET /\r\nHost: a\r\n\r\n", http.StatusBadRequest},
+		{"strict violation", "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", http.StatusBadRequest},
+		{"chunked not final", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, gzip\r\n\r\n", http.StatusBadRequest},
+		{"uri too long", "GET /" + strings.Repeat("a", 16) + " HTTP/1.1\r\nHost: a\r\n\r\n", http.StatusRequestURITooLong},
+		{"header too large", "GET / HTTP/1.1\r\nHost: a\r\nA: " + strings.Repeat("a", 64) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge},
+		{"unknown transfer coding", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: br, chunked\r\n\r\n0\r\n\r\n", http.StatusNotImplemented},
+		{"http/2 start-line", "GET / HTTP/2.0\r\nHost: a\r\n\r\n", http.StatusHTTPVersionNotSupported},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err, _ := http11.Parser(rawReader(test.raw), options)
+
+			var parseErr *http11.ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, test.status, parseErr.StatusCode)
+		})
+	}
+}
+
+func TestParserAcceptsLaterHTTP1MinorVersions(t *testing.T) {
+	req, err, _ := http11.Parser(rawReader("GET / HTTP/1.2\r\nHost: a\r\n\r\n"), http11.Options{})
+	require.NoError(t, err)
+	assert.True(t, req.ProtoAtLeast(1, 1))
+}
+
+func TestParserReadErrorsAreNotParseErrors(t *testing.T) {
+	// The client went away, there is nobody to answer
+	for _, raw := range []string{"", "GET / HTTP/1.1\r\nHost: a\r\n"} {
+		_, err, _ := http11.Parser(rawReader(raw), http11.Options{})
+
+		var parseErr *http11.ParseError
+		assert.ErrorIs(t, err, io.EOF)
+		assert.False(t, errors.As(err, &parseErr))
+	}
+}
+
+func TestHTTP11AnswersParseErrorsBeforeClosing(t *testing.T) {
+	router := chi.NewRouter()
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+	}{
+		{"chunked not final", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, gzip\r\n\r\n", http.StatusBadRequest},
+		{"uri too long", "GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\nHost: a\r\n\r\n", http.StatusRequestURITooLong},
+		{"header too large", "GET / HTTP/1.1\r\nHost: a\r\nA: " + strings.Repeat("a", 256) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge},
+		{"unknown transfer coding", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: br\r\n\r\n", http.StatusNotImplemented},
+		{"unsupported version", "GET / HTTP/3.0\r\nHost: a\r\n\r\n", http.StatusHTTPVersionNotSupported},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxy := &reverseproxy.Proxy{Logger: discardLogger{}, ParserOptions: http11.Options{MaxURILength: 32, MaxHeaderBytes: 128}}
+
+			// The valid request in front is answered as usual
+			responses := serveHTTP11(t, proxy, router, "GET / HTTP/1.1\r\nHost: a\r\n\r\n"+test.raw)
+
+			require.Len(t, responses, 2)
+			assert.Equal(t, http.StatusOK, responses[0].StatusCode)
+			assert.Equal(t, test.status, responses[1].StatusCode)
+			assert.True(t, responses[1].Close)
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/logging"
+	"httpServer/internal/reverseproxy"
+)
+
+type discardLogger struct{}
+
+func (discardLogger) Log(logging.LogLevel, string, ...interface{}) {}
+
+// tcpPair returns both ends of a loopback TCP connection, the handlers need a
+// remote address they can parse.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	server := <-accepted
+	require.NotNil(t, server)
+
+	return client, server
+}
+
+// serveHTTP11 runs HandleHTTP11 on one end of a connection, sends raw on the other
+// and returns the responses read back until the server is done. A nil proxy
+// keeps the one the handler was initialised with.
+func serveHTTP11(t *testing.T, proxy *reverseproxy.Proxy, router chi.Router, raw string) []*http.Response {
+	if proxy != nil {
+		handler.InitHandler(proxy, cache_structs.Channels{})
+	}
+
+	client, server := tcpPair(t)
+	defer client.Close()
+
+	go func() {
+		handler.HandleHTTP11(server, router)
+		_ = server.Close()
+	}()
+	go func() {
+		_, _ = io.WriteString(client, raw)
+	}()
+
+	var responses []*http.Response
+	reader := bufio.NewReader(client)
+	for {
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			break
+		}
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body = io.NopCloser(strings.NewReader(string(body)))
+		responses = append(responses, resp)
+	}
+
+	return responses
+}
+
+func bodyOf(t *testing.T, resp *http.Response) string {
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestPipelinedResponsesKeepRequestOrder(t *testing.T) {
+	fastStarted := make(chan struct{})
+
+	router := chi.NewRouter()
+	router.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		// Only finishes if the following request runs concurrently
+		select {
+		case <-fastStarted:
+		case <-time.After(2 * time.Second):
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		_, _ = w.Write([]byte("slow"))
+	})
+	router.Get("/fast", func(w http.ResponseWriter, r *http.Request) {
+		close(fastStarted)
+		_, _ = w.Write([]byte("fast"))
+	})
+	router.Get("/last", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("last"))
+	})
+
+	proxy := &reverseproxy.Proxy{Logger: discardLogger{}, MaxPipelinedRequests: 4}
+	responses := serveHTTP11(t, proxy, router,
+		"GET /slow HTTP/1.1\r\nHost: a\r\n\r\n"+
+			"GET /fast HTTP/1.1\r\nHost: a\r\n\r\n"+
+			"GET /last HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n")
+
+	require.Len(t, responses, 3)
+	for i, expected := range []string{"slow", "fast", "last"} {
+		assert.Equal(t, http.StatusOK, responses[i].StatusCode)
+		assert.Equal(t, expected, bodyOf(t, responses[i]))
+	}
+}
+
+func TestPipelinedResponsesAreNotBufferedWithoutBound(t *testing.T) {
+	large := strings.Repeat("x", 1<<20)
+	written := make(chan struct{})
+
+	router := chi.NewRouter()
+	router.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		// The following response can't be written completely while this one is pending
+		select {
+		case <-written:
+			_, _ = w.Write([]byte("buffered"))
+		case <-time.After(300 * time.Millisecond):
+			_, _ = w.Write([]byte("slow"))
+		}
+	})
+	router.Get("/large", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(large))
+		close(written)
+	})
+
+	proxy := &reverseproxy.Proxy{Logger: discardLogger{}, MaxPipelinedRequests: 4}
+	responses := serveHTTP11(t, proxy, router,
+		"GET /slow HTTP/1.1\r\nHost: a\r\n\r\n"+
+			"GET /large HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n")
+
+	require.Len(t, responses, 2)
+	assert.Equal(t, "slow", bodyOf(t, responses[0]))
+	assert.Equal(t, large, bodyOf(t, responses[1]))
+}
+
+func TestPipelinedRequestsSeeEarlierDeletes(t *testing.T) {
+	var deleted atomic.Bool
+	router := chi.NewRouter()
+	router.Delete("/x", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		deleted.Store(true)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Get("/x", func(w http.ResponseWriter, r *http.Request) {
+		if deleted.Load() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("x"))
+	})
+
+	proxy := &reverseproxy.Proxy{Logger: discardLogger{}, MaxPipelinedRequests: 4}
+	responses := serveHTTP11(t, proxy, router,
+		"DELETE /x HTTP/1.1\r\nHost: a\r\n\r\n"+
+			"GET /x HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n")
+
+	require.Len(t, responses, 2)
+	assert.Equal(t, http.StatusNoContent, responses[0].StatusCode)
+	assert.Equal(t, http.StatusNotFound, responses[1].StatusCode)
+}
+
+func TestPipelinedNonIdempotentRequestsAreSerialized(t *testing.T) {
+	running := 0
+	maxRunning := 0
+	enter := make(chan struct{}, 1)
+
+	track := func(w http.ResponseWriter, r *http.Request) {
+		enter <- struct{}{}
+		running++
+		maxRunning = max(maxRunning, running)
+		<-enter
+
+		time.Sleep(10 * time.Millisecond)
+
+		enter <- struct{}{}
+		running--
+		<-enter
+		_, _ = w.Write([]byte(r.Method))
+	}
+
+	router := chi.NewRouter()
+	router.Get("/", track)
+	router.Post("/", track)
+
+	proxy := &reverseproxy.Proxy{Logger: discardLogger{}, MaxPipelinedRequests: 4}
+	responses := serveHTTP11(t, proxy, router,
+		"GET / HTTP/1.1\r\nHost: a\r\n\r\n"+
+			"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0\r\n\r\n"+
+			"GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n")
+
+	require.Len(t, responses, 3)
+	for i, expected := range []string{"GET", "POST", "GET"} {
+		assert.Equal(t, expected, bodyOf(t, responses[i]))
+	}
+	assert.Equal(t, 1, maxRunning)
+}
@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/reverseproxy"
+	"httpServer/internal/reverseproxy/structs"
+)
+
+func TestPlainHTTPRedirect(t *testing.T) {
+	handler.InitHandler(&reverseproxy.Proxy{
+		Logger:    discardLogger{},
+		Port:      8443,
+		PlainHTTP: structs.PlainHTTP{Port: 8080, RedirectStatus: http.StatusPermanentRedirect},
+		Routes:    []structs.ProxyRoute{{Path: "/legacy", RedirectStatus: http.StatusMovedPermanently}},
+	}, cache_structs.Channels{})
+
+	tests := []struct {
+		target     string
+		statusCode int
+		location   string
+	}{
+		{"http://example.com:8080/app?x=1", http.StatusPermanentRedirect, "https://example.com:8443/app?x=1"},
+		{"http://example.com/legacy", http.StatusMovedPermanently, "https://example.com:8443/legacy"},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler.RedirectHandler(recorder, httptest.NewRequest(http.MethodGet, test.target, nil))
+
+		assert.Equal(t, test.statusCode, recorder.Code, test.target)
+		assert.Equal(t, test.location, recorder.Header().Get("Location"), test.target)
+	}
+
+	// Without a host there is nothing to redirect to
+	for _, host := range []string{"", ":8080"} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/app", nil)
+		req.Host = host
+		handler.RedirectHandler(recorder, req)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, host)
+		assert.Empty(t, recorder.Header().Get("Location"), host)
+	}
+}
+
+func TestACMEChallengePassthrough(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "token for "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	acmeHost, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	handler.InitHandler(&reverseproxy.Proxy{
+		Logger:    discardLogger{},
+		Port:      443,
+		PlainHTTP: structs.PlainHTTP{Port: 80, RedirectStatus: http.StatusPermanentRedirect, ACMEChallengeHost: acmeHost},
+	}, cache_structs.Channels{})
+
+	recorder := httptest.NewRecorder()
+	handler.ACMEChallengeHandler(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/.well-known/acme-challenge/abc", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "token for /.well-known/acme-challenge/abc", recorder.Body.String())
+}
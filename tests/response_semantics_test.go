@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	hpack "github.com/tatsuhiro-t/go-http2-hpack"
+	"httpServer/internal/http2/structs"
+	"httpServer/internal/response"
+	http11Response "httpServer/internal/response/http1.1"
+	http2Response "httpServer/internal/response/http2"
+)
+
+func TestFinalizeAddsHeaderFields(t *testing.T) {
+	header := http.Header{"Via": {"1.1 upstream"}, "Content-Length": {"0"}}
+	response.Finalize(header, http.StatusNoContent, response.Options{Server: "fttp", Via: "fttp"})
+
+	date, err := http.ParseTime(header.Get("Date"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), date, 2*time.Second)
+	assert.Equal(t, "fttp", header.Get("Server"))
+	assert.Equal(t, []string{"1.1 upstream", "1.1 fttp"}, header.Values("Via"))
+	assert.Empty(t, header.Get("Content-Length"))
+
+	header = http.Header{"Server": {"upstream"}}
+	response.Finalize(header, http.StatusOK, response.Options{})
+	assert.Equal(t, "upstream", header.Get("Server"))
+	assert.Empty(t, header.Values("Via"))
+}
+
+func TestKeepAlive(t *testing.T) {
+	tests := []struct {
+		name       string
+		raw        string
+		handler    string
+		keepAlive  bool
+		connection string
+	}{
+		{"HTTP/1.1 default", "GET / HTTP/1.1\r\nHost: a\r\n\r\n", "", true, ""},
+		{"client closes", "GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n", "", false, "close"},
+		{"handler closes", "GET / HTTP/1.1\r\nHost: a\r\n\r\n", "Close", false, "close"},
+		{"HTTP/1.0 default", "GET / HTTP/1.0\r\nHost: a\r\n\r\n", "", false, "close"},
+		{"HTTP/1.0 keep-alive", "GET / HTTP/1.0\r\nHost: a\r\nConnection: Keep-Alive\r\n\r\n", "", true, "keep-alive"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.ReadRequest(rawReader(test.raw))
+			require.NoError(t, err)
+
+			header := http.Header{}
+			if test.handler != "" {
+				header.Set("Connection", test.handler)
+			}
+			assert.Equal(t, test.keepAlive, response.KeepAlive(req, header))
+			assert.Equal(t, test.connection, header.Get("Connection"))
+		})
+	}
+}
+
+func TestHTTP11HeadResponseHasNoBody(t *testing.T) {
+	req, err := http.ReadRequest(rawReader("HEAD / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+
+	raw := writeResponse(t, req, func(w *http11Response.Response) {
+		_, err := w.Write([]byte("hello world"))
+		assert.NoError(t, err)
+	})
+
+	assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\n"))
+	resp, body := readResponse(t, raw, req)
+	assert.Equal(t, "11", resp.Header.Get("Content-Length"))
+	assert.NotEmpty(t, resp.Header.Get("Date"))
+	assert.Empty(t, body)
+}
+
+// decodeHeaderBlock decodes a complete header block with the decoder of the live HTTP/2 path.
+func decodeHeaderBlock(t *testing.T, dec *hpack.Decoder, block []byte) http.Header {
+	header := http.Header{}
+	for pos := 0; pos < len(block); {
+		field, n, err := dec.Decode(block[pos:], true)
+		require.NoError(t, err)
+		if field == nil {
+			break
+		}
+		header.Add(field.Name, field.Value)
+		pos += n
+	}
+	return header
+}
+
+func TestHTTP2ResponseSemantics(t *testing.T) {
+	essential := structs.NewResponseEssential(nil, hpack.NewEncoder(4096), response.Options{Server: "fttp"}, discardLogger{})
+	frames := make(chan []*structs.Frame)
+	go func() {
+		var received []*structs.Frame
+		for f := range essential.FrameChan {
+			received = append(received, f)
+		}
+		frames <- received
+	}()
+
+	req, err := http.NewRequest(http.MethodHead, "https://a/", nil)
+	require.NoError(t, err)
+
+	w := http2Response.NewResponse(nil, 1, *essential)
+	w.SetRequest(req)
+	w.Header().Set("Connection", "close")
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	w.Finish()
+	close(essential.FrameChan)
+
+	received := <-frames
+	require.Len(t, received, 2)
+
+	// A single HEADERS frame ends the stream, no DATA is sent for HEAD
+	assert.Equal(t, uint8(structs.HEADER_FRAME_TYPE), received[0].Type)
+	assert.Equal(t, uint8(structs.END_HEADERS|structs.END_STREAM), received[0].Flags)
+	header := decodeHeaderBlock(t, hpack.NewDecoder(), received[0].Payload)
+	assert.Equal(t, "200", header.Get(":status"))
+	assert.Equal(t, "fttp", header.Get("server"))
+	assert.NotEmpty(t, header.Get("date"))
+	assert.Empty(t, header.Get("connection"))
+
+	// The handler's Connection: close becomes a GOAWAY
+	assert.Equal(t, uint8(structs.GOAWAY_FRAME_TYPE), received[1].Type)
+	assert.Equal(t, []byte{0, 0, 0, 1, 0, 0, 0, 0}, received[1].Payload)
+}
@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/retry"
+	"httpServer/internal/reverseproxy"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryPolicy(t *testing.T) {
+	policy := &retry.Policy{
+		Statuses:      map[int]bool{http.StatusServiceUnavailable: true},
+		ConnectErrors: true,
+		Timeouts:      true,
+		BackoffBase:   10 * time.Millisecond,
+		BackoffMax:    50 * time.Millisecond,
+	}
+
+	unavailable := &http.Response{StatusCode: http.StatusServiceUnavailable}
+	internalError := &http.Response{StatusCode: http.StatusInternalServerError}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+
+	for _, test := range []struct {
+		idempotent bool
+		resp       *http.Response
+		err        error
+		retryable  bool
+	}{
+		{true, unavailable, nil, true},
+		{true, internalError, nil, false},
+		// Non-idempotent requests only if they can't have reached the upstream
+		{false, unavailable, nil, false},
+		{false, nil, refused, true},
+		{true, nil, timeoutError{}, true},
+		{false, nil, timeoutError{}, false},
+		{true, nil, reset, false},
+	} {
+		assert.Equal(t, test.retryable, policy.Retryable(test.idempotent, test.resp, test.err), "%+v", test)
+	}
+
+	for i, bound := range []time.Duration{10, 20, 40, 50, 50} {
+		for j := 0; j < 20; j++ {
+			assert.LessOrEqual(t, policy.Backoff(i), bound*time.Millisecond)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := retry.NewBudget(20, 0)
+
+	// A burst is allowed, then a fifth of the requests may be retried
+	for i := 0; i < 10; i++ {
+		assert.True(t, budget.Withdraw())
+	}
+	assert.False(t, budget.Withdraw())
+
+	for i := 0; i < 5; i++ {
+		budget.Deposit()
+	}
+	assert.True(t, budget.Withdraw())
+	assert.False(t, budget.Withdraw())
+}
+
+// flakyServer answers every request with failStatus until it was hit failures
+// times, then echoes the body.
+func flakyServer(failures int64, failStatus int) (*httptest.Server, *atomic.Int64) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if hits.Add(1) <= failures {
+			w.WriteHeader(failStatus)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	return server, &hits
+}
+
+func serveRetries(t *testing.T, upstreams string, retries string) {
+	handler.InitHandler(reverseproxy.NewReverseProxy(writeConfig(t,
+		"    - path: /\n      target_path: /\n      upstreams:\n"+upstreams+"      retries:\n"+retries)), cache_structs.Channels{})
+}
+
+func proxied(method string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ReverseProxyHandler(recorder, httptest.NewRequest(method, "/", strings.NewReader(body)))
+	return recorder
+}
+
+func TestRetriesOnRoute(t *testing.T) {
+	t.Run("idempotent requests are retried with their body", func(t *testing.T) {
+		server, hits := flakyServer(2, http.StatusServiceUnavailable)
+		defer server.Close()
+		serveRetries(t, fmt.Sprintf("        - url: %s\n", server.URL), "        attempts: 2\n        backoff_base: 1\n")
+
+		recorder := proxied(http.MethodPut, "payload")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "payload", recorder.Body.String())
+		assert.Equal(t, int64(3), hits.Load())
+	})
+
+	t.Run("attempts are limited", func(t *testing.T) {
+		server, hits := flakyServer(5, http.StatusBadGateway)
+		defer server.Close()
+		serveRetries(t, fmt.Sprintf("        - url: %s\n", server.URL), "        attempts: 2\n        backoff_base: 1\n")
+
+		assert.Equal(t, http.StatusBadGateway, proxied(http.MethodGet, "").Code)
+		assert.Equal(t, int64(3), hits.Load())
+	})
+
+	t.Run("non-idempotent requests that reached the upstream aren't retried", func(t *testing.T) {
+		server, hits := flakyServer(1, http.StatusServiceUnavailable)
+		defer server.Close()
+		serveRetries(t, fmt.Sprintf("        - url: %s\n", server.URL), "        attempts: 2\n")
+
+		assert.Equal(t, http.StatusServiceUnavailable, proxied(http.MethodPost, "payload").Code)
+		assert.Equal(t, int64(1), hits.Load())
+	})
+
+	t.Run("retries go to another upstream", func(t *testing.T) {
+		unreachable := httptest.NewServer(http.NotFoundHandler())
+		unreachable.Close()
+		server, hits := flakyServer(0, 0)
+		defer server.Close()
+		serveRetries(t, fmt.Sprintf("        - url: %s\n        - url: %s\n", unreachable.URL, server.URL),
+			"        attempts: 1\n        retry_on: [connect_error, 503]\n")
+
+		// Every other request starts at the unreachable upstream
+		for i := 0; i < 4; i++ {
+			recorder := proxied(http.MethodPost, "payload")
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, "payload", recorder.Body.String())
+		}
+		assert.Equal(t, int64(4), hits.Load())
+	})
+
+	t.Run("large bodies aren't kept for retries", func(t *testing.T) {
+		server, hits := flakyServer(1, http.StatusServiceUnavailable)
+		defer server.Close()
+		serveRetries(t, fmt.Sprintf("        - url: %s\n", server.URL), "        attempts: 2\n        max_body_bytes: 4\n")
+
+		assert.Equal(t, http.StatusServiceUnavailable, proxied(http.MethodPut, "payload").Code)
+		assert.Equal(t, int64(1), hits.Load())
+		assert.Equal(t, http.StatusOK, proxied(http.MethodPut, "tiny").Code)
+	})
+}
+
+func TestRetriesValidation(t *testing.T) {
+	for _, retries := range []string{
+		"        attempts: -1\n",
+		"        attempts: 2\n        retry_on: [5xx]\n",
+		"        attempts: 2\n        retry_on: [200]\n",
+		"        attempts: 2\n        budget_percent: 150\n",
+		"        attempts: 2\n        backoff_base: 100\n        backoff_max: 10\n",
+	} {
+		_, err := reverseproxy.LoadConfig(writeConfig(t, "    - path: /\n      host: http://127.0.0.1\n      target_path: /\n      retries:\n"+retries))
+		assert.Error(t, err, retries)
+	}
+}
@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/reverseproxy"
+)
+
+// writeConfig stores a config with the given routes section and returns its path.
+func writeConfig(t *testing.T, routes string) string {
+	dir := t.TempDir()
+	config := fmt.Sprintf("server:\n  port: 8443\n  routes:\n%s\nlogger:\n  level: error\n  file: %s\n", routes, filepath.Join(dir, "proxy.log"))
+
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+	return path
+}
+
+func TestRouteMatching(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer upstream.Close()
+
+	route := func(path, match, targetPath string) string {
+		return fmt.Sprintf("    - path: %q\n      match: %s\n      host: %s\n      target_path: %q\n", path, match, upstream.URL, targetPath)
+	}
+	proxy := reverseproxy.NewReverseProxy(writeConfig(t,
+		route("/api/v1/users", "exact", "/users")+
+			route("/api", "prefix", "/backend")+
+			route("/api/v2/", "prefix", "/")+
+			route("/static/*/**", "glob", "/assets/$1/$2")+
+			route(`/items/(?P<id>[0-9]+)`, "regex", "/item?id=${id}")+
+			route("/items/", "prefix", "/catalog/")))
+	handler.InitHandler(proxy, cache_structs.Channels{})
+
+	tests := []struct {
+		target   string
+		upstream string
+	}{
+		// Exact routes ignore the prefix route covering them
+		{"/api/v1/users?id=3", "/users?id=3"},
+		{"/api", "/backend"},
+		{"/api/v1/orders?page=2&sort=asc", "/backend/v1/orders?page=2&sort=asc"},
+		// The longest prefix wins and is stripped
+		{"/api/v2/orders", "/orders"},
+		{"/static/css/site/main.css", "/assets/css/site/main.css"},
+		{"/items/42", "/item?id=42"},
+		{"/items/42?fields=name", "/item?id=42&fields=name"},
+		// The regex has to match the whole path, the prefix catches the rest
+		{"/items/42/reviews", "/catalog/42/reviews"},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, test.target, nil)
+		handler.ReverseProxyHandler(recorder, req)
+
+		assert.Equal(t, http.StatusOK, recorder.Code, test.target)
+		assert.Equal(t, test.upstream, recorder.Body.String(), test.target)
+	}
+}
+
+func TestRoutePrefixStopsAtSegmentBoundary(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer upstream.Close()
+
+	proxy := reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf("    - path: /api\n      match: prefix\n      host: %s\n      target_path: /\n", upstream.URL)))
+	handler.InitHandler(proxy, cache_structs.Channels{})
+
+	recorder := httptest.NewRecorder()
+	handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/apis", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestRoutePathsStayBelowTargetPath(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer upstream.Close()
+
+	route := func(path, match, targetPath string) string {
+		return fmt.Sprintf("    - path: %q\n      match: %s\n      host: %s\n      target_path: %q\n", path, match, upstream.URL, targetPath)
+	}
+	handler.InitHandler(reverseproxy.NewReverseProxy(writeConfig(t,
+		route("/api", "prefix", "/v1")+
+			route(`/dl/([a-z.]+)-([a-z.]+)`, "regex", "/store/$1/$2")+
+			route("/", "prefix", "/public"))), cache_structs.Channels{})
+
+	for _, test := range []struct {
+		target   string
+		status   int
+		upstream string
+	}{
+		// Dot segments are resolved before routing, also when they are encoded
+		{"/api/../secret", http.StatusOK, "/public/secret"},
+		{"/api/%2e%2E/secret", http.StatusOK, "/public/secret"},
+		{"/api/a/./b/../c", http.StatusOK, "/v1/a/c"},
+		{"/api/../../..", http.StatusOK, "/public"},
+		// Encoded slashes stay within their segment, unreserved characters are decoded
+		{"/api/users%2Fadmin", http.StatusOK, "/v1/users%2Fadmin"},
+		{"/api/%75sers", http.StatusOK, "/v1/users"},
+		// Captures can't build dot segments either
+		{"/dl/..-..", http.StatusBadRequest, ""},
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, test.target, nil))
+
+		assert.Equal(t, test.status, recorder.Code, test.target)
+		if test.status == http.StatusOK {
+			assert.Equal(t, test.upstream, recorder.Body.String(), test.target)
+		}
+	}
+}
+
+func TestRouteMatchValidation(t *testing.T) {
+	for _, routes := range []string{
+		"    - path: /a\n      match: fuzzy\n      host: http://127.0.0.1\n      target_path: /\n",
+		"    - path: /a(\n      match: regex\n      host: http://127.0.0.1\n      target_path: /\n",
+	} {
+		_, err := reverseproxy.LoadConfig(writeConfig(t, routes))
+		assert.Error(t, err, routes)
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	route := func(path, hosts, targetPath string) string {
+		return fmt.Sprintf("    - path: %q\n      match: prefix\n      hosts: %s\n      host: %s\n      target_path: %q\n", path, hosts, upstream.URL, targetPath)
+	}
+	proxy := reverseproxy.NewReverseProxy(writeConfig(t,
+		route("/", `["api.example.com"]`, "/api")+
+			route("/", `["*.example.com"]`, "/wildcard")+
+			route("/", `["*.eu.example.com"]`, "/eu")+
+			route("/", `[]`, "/default")+
+			route("/admin", `["App.Example.com."]`, "/admin")))
+	handler.InitHandler(proxy, cache_structs.Channels{})
+
+	tests := []struct {
+		host     string
+		path     string
+		upstream string
+	}{
+		{"api.example.com", "/x", "/api/x"},
+		{"API.example.com:8443", "/x", "/api/x"},
+		{"shop.example.com", "/x", "/wildcard/x"},
+		// The longest wildcard wins
+		{"shop.eu.example.com", "/x", "/eu/x"},
+		// Wildcards only cover subdomains
+		{"example.com", "/x", "/default/x"},
+		{"other.org", "/x", "/default/x"},
+		{"app.example.com", "/admin", "/admin"},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		req.Host = test.host
+		handler.ReverseProxyHandler(recorder, req)
+
+		assert.Equal(t, http.StatusOK, recorder.Code, test.host)
+		assert.Equal(t, test.upstream, recorder.Body.String(), test.host)
+	}
+
+	// A host with routes of its own doesn't fall back to the default server
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/x", nil)
+	req.Host = "app.example.com"
+	handler.ReverseProxyHandler(recorder, req)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestVirtualHostValidation(t *testing.T) {
+	for _, routes := range []string{
+		"    - path: /a\n      hosts: [a.example.com]\n      host: http://127.0.0.1\n      target_path: /\n" +
+			"    - path: /a\n      hosts: [b.example.com, A.example.com]\n      host: http://127.0.0.1\n      target_path: /\n",
+		"    - path: /a\n      host: http://127.0.0.1\n      target_path: /\n" +
+			"    - path: /a\n      match: exact\n      host: http://127.0.0.1\n      target_path: /\n",
+		"    - path: /a\n      hosts: [\"a*.example.com\"]\n      host: http://127.0.0.1\n      target_path: /\n",
+		"    - path: /a\n      hosts: [\"example.com:443\"]\n      host: http://127.0.0.1\n      target_path: /\n",
+	} {
+		_, err := reverseproxy.LoadConfig(writeConfig(t, routes))
+		assert.Error(t, err, routes)
+	}
+
+	// The same path on different host patterns is fine, even when they cover the same host
+	_, err := reverseproxy.LoadConfig(writeConfig(t,
+		"    - path: /a\n      hosts: [a.example.com]\n      host: http://127.0.0.1\n      target_path: /\n"+
+			"    - path: /a\n      hosts: [\"*.example.com\"]\n      host: http://127.0.0.1\n      target_path: /\n"+
+			"    - path: /a\n      host: http://127.0.0.1\n      target_path: /\n"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"httpServer/internal/handler"
+	http11Response "httpServer/internal/response/http1.1"
+	"httpServer/internal/reverseproxy/structs"
+)
+
+func TestResponseFlushStartsChunkedBody(t *testing.T) {
+	req := httpRequest(t, "HTTP/1.1")
+	raw := writeResponse(t, req, func(w *http11Response.Response) {
+		_, _ = w.Write([]byte("first"))
+		w.Flush()
+		_, _ = w.Write([]byte("second"))
+	})
+
+	resp, body := readResponse(t, raw, req)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "firstsecond", body)
+}
+
+func TestEventStreamIsFlushedImmediately(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		<-release
+		_, _ = io.WriteString(w, "data: second\n\n")
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	proxyTo(t, upstream, structs.ProxyRoute{Path: "/events"})
+	router := chi.NewRouter()
+	router.HandleFunc("/events", handler.ReverseProxyHandler)
+
+	client, server := tcpPair(t)
+	defer client.Close()
+	go func() {
+		handler.HandleHTTP11(server, router)
+		_ = server.Close()
+	}()
+
+	_, err := io.WriteString(client, "GET /events HTTP/1.1\r\nHost: a\r\n\r\n")
+	require.NoError(t, err)
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	reader := bufio.NewReader(client)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// The first event arrives while the upstream is still holding back the second
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: first", strings.TrimSpace(line))
+}
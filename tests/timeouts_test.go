@@ -0,0 +1,253 @@
+package tests
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	hpack "github.com/tatsuhiro-t/go-http2-hpack"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/http2/frame"
+	"httpServer/internal/http2/structs"
+	"httpServer/internal/response"
+	http2Response "httpServer/internal/response/http2"
+	"httpServer/internal/reverseproxy"
+	proxystructs "httpServer/internal/reverseproxy/structs"
+)
+
+// serveTimeouts runs HandleHTTP11 with timeouts on one end of a connection and
+// returns the other end together with a channel closed once the server is done.
+func serveTimeouts(t *testing.T, timeouts proxystructs.Timeouts, router chi.Router) (net.Conn, chan struct{}) {
+	handler.InitHandler(&reverseproxy.Proxy{Logger: discardLogger{}, Timeouts: timeouts}, cache_structs.Channels{})
+
+	client, server := tcpPair(t)
+	t.Cleanup(func() { _ = client.Close() })
+
+	done := make(chan struct{})
+	go func() {
+		handler.HandleHTTP11(server, router)
+		_ = server.Close()
+		close(done)
+	}()
+
+	return client, done
+}
+
+func waitDone(t *testing.T, done chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the connection was not closed in time")
+	}
+}
+
+func TestHTTP11ClosesIdleConnection(t *testing.T) {
+	router := chi.NewRouter()
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	client, done := serveTimeouts(t, proxystructs.Timeouts{Idle: 100 * time.Millisecond}, router)
+	_, err := io.WriteString(client, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(client)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", bodyOf(t, resp))
+
+	waitDone(t, done)
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestHTTP11ReadHeaderTimeout(t *testing.T) {
+	client, done := serveTimeouts(t, proxystructs.Timeouts{ReadHeader: 100 * time.Millisecond}, chi.NewRouter())
+
+	// A slowloris client never finishes its header section
+	_, err := io.WriteString(client, "GET / HTTP/1.1\r\nHost: a\r\n")
+	require.NoError(t, err)
+
+	waitDone(t, done)
+}
+
+func TestHTTP11ReadBodyTimeout(t *testing.T) {
+	bodyErr := make(chan error, 1)
+	router := chi.NewRouter()
+	router.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		bodyErr <- err
+	})
+
+	client, done := serveTimeouts(t, proxystructs.Timeouts{ReadBody: 100 * time.Millisecond}, router)
+	_, err := io.WriteString(client, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\nabc")
+	require.NoError(t, err)
+
+	select {
+	case err := <-bodyErr:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("reading the body did not time out")
+	}
+	waitDone(t, done)
+}
+
+func TestHTTP11ReadBodyTimeoutIsIdle(t *testing.T) {
+	router := chi.NewRouter()
+	router.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write(body)
+	})
+
+	client, _ := serveTimeouts(t, proxystructs.Timeouts{ReadBody: 100 * time.Millisecond}, router)
+	_, err := io.WriteString(client, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\n")
+	require.NoError(t, err)
+
+	// The upload takes longer than ReadBody, but never pauses for that long
+	for i := 0; i < 10; i++ {
+		time.Sleep(40 * time.Millisecond)
+		_, err = io.WriteString(client, "x")
+		require.NoError(t, err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, strings.Repeat("x", 10), bodyOf(t, resp))
+}
+
+func TestHTTP11WriteTimeout(t *testing.T) {
+	writeErr := make(chan error, 1)
+	router := chi.NewRouter()
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		chunk := []byte(strings.Repeat("x", 64*1024))
+		for {
+			if _, err := w.Write(chunk); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+	})
+
+	// The client never reads the response
+	client, done := serveTimeouts(t, proxystructs.Timeouts{Write: 100 * time.Millisecond}, router)
+	_, err := io.WriteString(client, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	require.NoError(t, err)
+
+	select {
+	case err := <-writeErr:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("writing to the client did not time out")
+	}
+
+	// The server lingers until the client hangs up
+	_ = client.Close()
+	waitDone(t, done)
+}
+
+func TestHTTP2IdleConnectionGetsGoAway(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		frames func(client net.Conn) error
+	}{
+		{"no streams", func(net.Conn) error { return nil }},
+		// A stream that ended with a frame that couldn't be parsed isn't open anymore
+		{"broken header frame", func(client net.Conn) error {
+			return http2Response.SendFrame(client, structs.HEADER_FRAME_TYPE, structs.END_HEADERS|structs.PADDED, 1, []byte{0xff})
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			handler.InitHandler(&reverseproxy.Proxy{Logger: discardLogger{}, Timeouts: proxystructs.Timeouts{Idle: 100 * time.Millisecond}}, cache_structs.Channels{})
+
+			certServer := httptest.NewTLSServer(http.NotFoundHandler())
+			certificates := certServer.TLS.Certificates
+			certServer.Close()
+
+			ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certificates, NextProtos: []string{"h2"}})
+			require.NoError(t, err)
+			defer ln.Close()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+
+				tlsConn := conn.(*tls.Conn)
+				if tlsConn.Handshake() == nil {
+					handler.HandleHTTP2(tlsConn, chi.NewRouter())
+				}
+			}()
+
+			client, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+			require.NoError(t, err)
+			defer client.Close()
+			require.NoError(t, client.SetReadDeadline(time.Now().Add(2*time.Second)))
+
+			_, err = io.WriteString(client, http2Response.ConnectionPreface)
+			require.NoError(t, err)
+			require.NoError(t, http2Response.SendFrame(client, structs.SETTINGS_FRAME_TYPE, 0, 0, nil))
+			require.NoError(t, test.frames(client))
+
+			reader := bufio.NewReader(client)
+			for {
+				f, err := frame.ParseFrame(reader)
+				require.NoError(t, err)
+				if f.Type != structs.GOAWAY_FRAME_TYPE {
+					continue
+				}
+
+				require.Len(t, f.Payload, 8)
+				assert.Equal(t, uint32(http2Response.ERROR_CODE_NO_ERROR), binary.BigEndian.Uint32(f.Payload[4:]))
+				break
+			}
+
+			waitDone(t, done)
+		})
+	}
+}
+
+func TestHTTP2StreamsStopOnceFramesCantBeSent(t *testing.T) {
+	server, client := net.Pipe()
+	_ = client.Close()
+
+	essential := structs.NewResponseEssential(server, hpack.NewEncoder(4096), response.Options{}, discardLogger{})
+	go http2Response.SendFrames(*essential)
+
+	// Neither the streams nor GOAWAY wait for a writer that gave up
+	done := make(chan error, 1)
+	go func() {
+		w := http2Response.NewResponse(nil, 1, *essential)
+		w.SetRequest(httptest.NewRequest(http.MethodGet, "/", nil))
+		_, err := w.Write([]byte(strings.Repeat("x", 3*http2Response.MAX_DATA_BODY_LENGTH)))
+		w.Finish()
+		http2Response.SendGoAway(*essential, 1, http2Response.ERROR_CODE_NO_ERROR)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the stream blocked on the frame writer")
+	}
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"httpServer/internal/handler"
+	"httpServer/internal/reverseproxy/structs"
+)
+
+// echoUpstream switches to the "echo" protocol and sends back whatever it receives.
+func echoUpstream(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = rw.Flush()
+		_, _ = io.Copy(conn, rw)
+	}))
+}
+
+func TestUpgradeIsTunnelled(t *testing.T) {
+	upstream := echoUpstream(t)
+	defer upstream.Close()
+
+	proxyTo(t, upstream, structs.ProxyRoute{Path: "/ws", AllowUpgrade: true, UpgradeIdleTimeout: time.Second})
+	router := chi.NewRouter()
+	router.HandleFunc("/ws", handler.ReverseProxyHandler)
+
+	client, server := tcpPair(t)
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		handler.HandleHTTP11(server, router)
+		_ = server.Close()
+		close(done)
+	}()
+
+	_, err := io.WriteString(client, "GET /ws HTTP/1.1\r\nHost: a\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(client)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "echo", resp.Header.Get("Upgrade"))
+
+	_, err = io.WriteString(client, "ping")
+	require.NoError(t, err)
+	echo := make([]byte, 4)
+	_, err = io.ReadFull(reader, echo)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(echo))
+
+	// The tunnel is closed once it idles out
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle tunnel wasn't closed")
+	}
+}
+
+func TestUpgradeNeedsRouteEnablement(t *testing.T) {
+	upstream := echoUpstream(t)
+	defer upstream.Close()
+
+	proxyTo(t, upstream, structs.ProxyRoute{Path: "/ws"})
+	router := chi.NewRouter()
+	router.HandleFunc("/ws", handler.ReverseProxyHandler)
+
+	responses := serveHTTP11(t, nil, router, "GET /ws HTTP/1.1\r\nHost: a\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"+
+		"GET /ws HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n")
+	require.Len(t, responses, 2)
+	assert.Equal(t, http.StatusBadRequest, responses[0].StatusCode)
+}
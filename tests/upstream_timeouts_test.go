@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/reverseproxy"
+	"httpServer/internal/reverseproxy/structs"
+)
+
+// serveSlowUpstream routes to an upstream that waits headerDelay before it
+// answers and bodyDelay in the middle of its body. The configured timeouts are
+// whole seconds, so the route gets timeouts set directly.
+func serveSlowUpstream(t *testing.T, headerDelay, bodyDelay time.Duration, timeouts structs.UpstreamTimeouts) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(headerDelay)
+		_, _ = io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		time.Sleep(bodyDelay)
+		_, _ = io.WriteString(w, " complete")
+	}))
+	t.Cleanup(upstream.Close)
+
+	proxy := reverseproxy.NewReverseProxy(writeConfig(t, fmt.Sprintf("    - path: /\n      host: %s\n      target_path: /\n", upstream.URL)))
+	proxy.Routes[0].Timeouts = timeouts
+	proxy.Routes[0].Transport = handler.NewTransport(&proxy.Routes[0])
+	handler.InitHandler(proxy, cache_structs.Channels{})
+}
+
+func proxiedWithHeader(name, value string) (*httptest.ResponseRecorder, time.Duration) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if name != "" {
+		req.Header.Set(name, value)
+	}
+
+	start := time.Now()
+	handler.ReverseProxyHandler(recorder, req)
+	return recorder, time.Since(start)
+}
+
+func TestUpstreamResponseHeaderTimeout(t *testing.T) {
+	serveSlowUpstream(t, 300*time.Millisecond, 0, structs.UpstreamTimeouts{ResponseHeader: 50 * time.Millisecond})
+
+	recorder, elapsed := proxiedWithHeader("", "")
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Less(t, elapsed, 250*time.Millisecond)
+}
+
+func TestUpstreamBodyIdleTimeout(t *testing.T) {
+	serveSlowUpstream(t, 0, 300*time.Millisecond, structs.UpstreamTimeouts{BodyIdle: 50 * time.Millisecond})
+
+	// The status is sent already, the body is cut short
+	recorder, elapsed := proxiedWithHeader("", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "partial", recorder.Body.String())
+	assert.Less(t, elapsed, 250*time.Millisecond)
+}
+
+func TestUpstreamTotalTimeoutAndClientHints(t *testing.T) {
+	for _, test := range []struct {
+		total  time.Duration
+		header string
+		value  string
+		status int
+	}{
+		{50 * time.Millisecond, "", "", http.StatusGatewayTimeout},
+		{0, "grpc-timeout", "50m", http.StatusGatewayTimeout},
+		{0, "Request-Timeout", "0.05", http.StatusGatewayTimeout},
+		// Hints can't extend the timeout of the route
+		{50 * time.Millisecond, "grpc-timeout", "10S", http.StatusGatewayTimeout},
+		{50 * time.Millisecond, "Request-Timeout", "10", http.StatusGatewayTimeout},
+		// Malformed hints are ignored
+		{0, "grpc-timeout", "50x", http.StatusOK},
+		{0, "grpc-timeout", "123456789m", http.StatusOK},
+		{0, "Request-Timeout", "NaN", http.StatusOK},
+	} {
+		serveSlowUpstream(t, 150*time.Millisecond, 0, structs.UpstreamTimeouts{Total: test.total})
+
+		recorder, elapsed := proxiedWithHeader(test.header, test.value)
+		assert.Equal(t, test.status, recorder.Code, "%+v", test)
+		if test.status == http.StatusGatewayTimeout {
+			assert.Less(t, elapsed, 120*time.Millisecond, "%+v", test)
+		}
+	}
+}
+
+func TestUpstreamTimeoutsValidation(t *testing.T) {
+	_, err := reverseproxy.LoadConfig(writeConfig(t, "    - path: /\n      host: http://127.0.0.1\n      target_path: /\n      upstream_timeouts:\n        total: -1\n"))
+	assert.Error(t, err)
+}
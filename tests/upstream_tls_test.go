@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cache_structs "httpServer/internal/cache/structs"
+	"httpServer/internal/handler"
+	"httpServer/internal/reverseproxy"
+)
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+// caFile writes the self-signed certificate of a TLS test server to a CA file.
+func caFile(t *testing.T, server *httptest.Server) string {
+	return writePEM(t, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+}
+
+func serveUpstreamTLS(t *testing.T, upstream *httptest.Server, upstreamTLS string) *httptest.ResponseRecorder {
+	route := fmt.Sprintf("    - path: /\n      host: %s\n      target_path: /\n", upstream.URL)
+	if upstreamTLS != "" {
+		route += "      upstream_tls:\n" + upstreamTLS
+	}
+	handler.InitHandler(reverseproxy.NewReverseProxy(writeConfig(t, route)), cache_structs.Channels{})
+
+	recorder := httptest.NewRecorder()
+	handler.ReverseProxyHandler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder
+}
+
+func TestUpstreamCertificateVerification(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.ServerName)
+	}))
+	defer upstream.Close()
+
+	ca := fmt.Sprintf("        ca_file: %s\n", caFile(t, upstream))
+	spki := sha256.Sum256(upstream.Certificate().RawSubjectPublicKeyInfo)
+	pin := fmt.Sprintf("        pinned_spki: [%s]\n", base64.StdEncoding.EncodeToString(spki[:]))
+	wrongPin := fmt.Sprintf("        pinned_spki: [%s]\n", base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)))
+
+	for _, test := range []struct {
+		upstreamTLS string
+		status      int
+		serverName  string
+	}{
+		// The system doesn't trust the test certificate
+		{"", http.StatusBadGateway, ""},
+		{ca, http.StatusOK, ""},
+		{ca + "        server_name: example.com\n", http.StatusOK, "example.com"},
+		{ca + "        server_name: other.test\n", http.StatusBadGateway, ""},
+		{"        insecure_skip_verify: true\n", http.StatusOK, ""},
+		{ca + pin, http.StatusOK, ""},
+		{ca + wrongPin, http.StatusBadGateway, ""},
+		{"        insecure_skip_verify: true\n" + wrongPin, http.StatusBadGateway, ""},
+	} {
+		recorder := serveUpstreamTLS(t, upstream, test.upstreamTLS)
+		assert.Equal(t, test.status, recorder.Code, test.upstreamTLS)
+		if test.status == http.StatusOK {
+			assert.Equal(t, test.serverName, recorder.Body.String(), test.upstreamTLS)
+		}
+	}
+}
+
+// selfSigned creates a certificate for 127.0.0.1 signed by its own key.
+func selfSigned(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: certificate}
+}
+
+func TestPinsOnlyTrustVerifiedOrLeafCertificates(t *testing.T) {
+	leaf := selfSigned(t, "leaf")
+	pinned := selfSigned(t, "pinned")
+
+	// The upstream sends the pinned certificate along without holding its key
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	upstream.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{leaf.Certificate[0], pinned.Certificate[0]},
+		PrivateKey:  leaf.PrivateKey,
+	}}}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	pin := func(certificate tls.Certificate) string {
+		spki := sha256.Sum256(certificate.Leaf.RawSubjectPublicKeyInfo)
+		return fmt.Sprintf("        pinned_spki: [%s]\n", base64.StdEncoding.EncodeToString(spki[:]))
+	}
+	ca := fmt.Sprintf("        ca_file: %s\n", writePEM(t, "ca.pem", "CERTIFICATE", leaf.Certificate[0]))
+
+	for _, test := range []struct {
+		upstreamTLS string
+		status      int
+	}{
+		{ca + pin(leaf), http.StatusOK},
+		// Not part of the verified chain
+		{ca + pin(pinned), http.StatusBadGateway},
+		{"        insecure_skip_verify: true\n" + pin(leaf), http.StatusOK},
+		// Without verification only the leaf counts
+		{"        insecure_skip_verify: true\n" + pin(pinned), http.StatusBadGateway},
+	} {
+		assert.Equal(t, test.status, serveUpstreamTLS(t, upstream, test.upstreamTLS).Code, test.upstreamTLS)
+	}
+}
+
+func TestUpstreamClientCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "proxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(certificate)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	upstream.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	ca := fmt.Sprintf("        ca_file: %s\n", caFile(t, upstream))
+	assert.Equal(t, http.StatusBadGateway, serveUpstreamTLS(t, upstream, ca).Code)
+
+	recorder := serveUpstreamTLS(t, upstream, ca+fmt.Sprintf("        cert_file: %s\n        key_file: %s\n",
+		writePEM(t, "client.pem", "CERTIFICATE", der), writePEM(t, "client-key.pem", "PRIVATE KEY", pkcs8)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "proxy", recorder.Body.String())
+}
+
+func TestUpstreamTLSValidation(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o600))
+
+	for _, upstreamTLS := range []string{
+		"        cert_file: client.pem\n",
+		"        ca_file: /nonexistent/ca.pem\n",
+		"        ca_file: " + notPEM + "\n",
+		"        pinned_spki: [abc]\n",
+		"        pinned_spki: [" + base64.StdEncoding.EncodeToString([]byte("too short")) + "]\n",
+	} {
+		_, err := reverseproxy.LoadConfig(writeConfig(t, "    - path: /\n      host: https://127.0.0.1\n      target_path: /\n      upstream_tls:\n"+upstreamTLS))
+		assert.Error(t, err, upstreamTLS)
+	}
+}
@@ -1,41 +1,240 @@
+// Command staticTable generates the HPACK static table and Huffman tables of
+// internal/hpack. It is run through go generate from the hpack package:
+//
+//	go run ../../tools/staticTable -content ../../tools/staticTable/staticTableContent.txt -huffman ../../tools/staticTable/huffmanCodeContent.txt
+//
+// staticTableContent.txt holds one "index;name;value" entry per line (RFC 7541 Appendix A),
+// huffmanCodeContent.txt one "symbol;code in hex;code length in bits" entry per line (RFC 7541 Appendix B).
 package main
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
+	"go/format"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
-func main() {
-	var path = flag.String("content", "", "The content of the file to insert")
-	flag.Parse()
+const header = "// Code generated by tools/staticTable; DO NOT EDIT.\n\npackage hpack\n\n"
 
-	if *path == "" {
-		panic("The file path is required")
-	}
+type staticEntry struct {
+	name  string
+	value string
+}
+
+type huffmanEntry struct {
+	code   uint32
+	length uint8
+}
 
-	f, err := os.Open(*path)
+func readLines(path string, fields int) ([][]string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer f.Close()
 
+	var lines [][]string
 	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		splitLine := strings.Split(scanner.Text(), ";")
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		splitLine := strings.SplitN(scanner.Text(), ";", fields)
+		if len(splitLine) != fields {
+			return nil, fmt.Errorf("%s:%d: expected %d fields, got %q", path, lineNumber, fields, scanner.Text())
+		}
+		for i := range splitLine {
+			splitLine[i] = strings.TrimSpace(splitLine[i])
+		}
+
+		lines = append(lines, splitLine)
+	}
+
+	return lines, scanner.Err()
+}
+
+func readStaticTable(path string) ([]staticEntry, error) {
+	lines, err := readLines(path, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []staticEntry
+	for i, line := range lines {
+		if line[0] != strconv.Itoa(i+1) {
+			return nil, fmt.Errorf("%s: entry %q is out of order, expected index %d", path, line[0], i+1)
+		}
+		entries = append(entries, staticEntry{name: line[1], value: line[2]})
+	}
+
+	return entries, nil
+}
+
+func readHuffmanCodes(path string) ([]huffmanEntry, error) {
+	lines, err := readLines(path, 3)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) != 257 {
+		return nil, fmt.Errorf("%s: expected 257 codes, got %d", path, len(lines))
+	}
+
+	var entries []huffmanEntry
+	for i, line := range lines {
+		if line[0] != strconv.Itoa(i) {
+			return nil, fmt.Errorf("%s: symbol %q is out of order, expected %d", path, line[0], i)
+		}
+
+		code, err := strconv.ParseUint(line[1], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: symbol %d: %v", path, i, err)
+		}
+		length, err := strconv.ParseUint(line[2], 10, 8)
+		if err != nil || length == 0 || length > 30 {
+			return nil, fmt.Errorf("%s: symbol %d: invalid code length %q", path, i, line[2])
+		}
+
+		entries = append(entries, huffmanEntry{code: uint32(code), length: uint8(length)})
+	}
+
+	return entries, nil
+}
+
+func generateStaticTable(entries []staticEntry) []byte {
+	var b bytes.Buffer
+	b.WriteString(header)
+
+	fmt.Fprintf(&b, "const STATIC_TABLE_SIZE = %d\n\n", len(entries))
+
+	b.WriteString("var staticTable = [STATIC_TABLE_SIZE]HeaderField{\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "{HeaderFieldName: %q, HeaderFieldValue: %q},\n", entry.name, entry.value)
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// staticNameIndex maps a header name to the first static table index carrying it.\n")
+	b.WriteString("// Entries with the same name are adjacent in the static table.\n")
+	b.WriteString("var staticNameIndex = map[string]int{\n")
+	seen := make(map[string]bool)
+	for i, entry := range entries {
+		if seen[entry.name] {
+			continue
+		}
+		seen[entry.name] = true
+		fmt.Fprintf(&b, "%q: %d,\n", entry.name, i+1)
+	}
+	b.WriteString("}\n")
+
+	return b.Bytes()
+}
+
+// buildDecodeTree flattens the Huffman code into a binary tree. Every node holds
+// two children; a positive child is the index of the next node, a negative
+// child -(symbol+1) is a leaf, and zero is an invalid code.
+func buildDecodeTree(codes []huffmanEntry) ([][2]int16, error) {
+	tree := [][2]int16{{0, 0}}
+
+	for symbol, code := range codes {
+		node := 0
+		for i := int(code.length) - 1; i >= 0; i-- {
+			bit := (code.code >> uint(i)) & 1
+			child := tree[node][bit]
+
+			if child < 0 {
+				return nil, fmt.Errorf("code of symbol %d is prefixed by the code of symbol %d", symbol, -child-1)
+			}
 
-		for _, element := range splitLine {
-			element = strings.Trim(element, ";")
-			element = strings.TrimSpace(element)
+			if i == 0 {
+				if child != 0 {
+					return nil, fmt.Errorf("code of symbol %d is a prefix of another code", symbol)
+				}
+				tree[node][bit] = int16(-(symbol + 1))
+				break
+			}
+
+			if child == 0 {
+				tree = append(tree, [2]int16{0, 0})
+				child = int16(len(tree) - 1)
+				tree[node][bit] = child
+			}
+			node = int(child)
 		}
+	}
+
+	return tree, nil
+}
+
+func generateHuffmanTables(codes []huffmanEntry) ([]byte, error) {
+	tree, err := buildDecodeTree(codes)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString(header)
+
+	b.WriteString("var huffmanCodes = [257]huffmanCode{\n")
+	for symbol, code := range codes {
+		fmt.Fprintf(&b, "{0x%x, %d}, // %d\n", code.code, code.length, symbol)
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// huffmanDecodeTree is the Huffman code as a binary tree rooted at index 0. A positive\n")
+	b.WriteString("// child is the index of the next node, a negative child -(symbol+1) a leaf and 0 an invalid code.\n")
+	b.WriteString("var huffmanDecodeTree = [...][2]int16{\n")
+	for _, node := range tree {
+		fmt.Fprintf(&b, "{%d, %d},\n", node[0], node[1])
+	}
+	b.WriteString("}\n")
+
+	return b.Bytes(), nil
+}
+
+func writeSource(path string, src []byte) error {
+	formatted, err := format.Source(src)
+	if err != nil {
+		return fmt.Errorf("formatting %s: %v", path, err)
+	}
+
+	return os.WriteFile(path, formatted, 0644)
+}
 
-		fmt.Printf("IndexAddressSpace_ = append(IndexAddressSpace_, *NewHeaderField(\"%v\", \"%v\", false))\n", splitLine[1], splitLine[2])
+func main() {
+	var contentPath = flag.String("content", "", "The static table content file")
+	var huffmanPath = flag.String("huffman", "", "The Huffman code table file")
+	var outDir = flag.String("out", ".", "The directory the generated files are written to")
+	flag.Parse()
+
+	if *contentPath == "" || *huffmanPath == "" {
+		log.Fatal("The static table and Huffman code file paths are required")
+	}
+
+	staticEntries, err := readStaticTable(*contentPath)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if err := scanner.Err(); err != nil {
+	huffmanEntries, err := readHuffmanCodes(*huffmanPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	huffmanSource, err := generateHuffmanTables(huffmanEntries)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := writeSource(filepath.Join(*outDir, "static_table_gen.go"), generateStaticTable(staticEntries)); err != nil {
+		log.Fatal(err)
+	}
+	if err := writeSource(filepath.Join(*outDir, "huffman_table_gen.go"), huffmanSource); err != nil {
 		log.Fatal(err)
 	}
 }